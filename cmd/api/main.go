@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"seckill-system/internal/database"
 	"seckill-system/internal/handler"
 	"seckill-system/internal/middleware"
+	"seckill-system/internal/pkg/lock"
+	"seckill-system/internal/pkg/metrics"
 	mqPkg "seckill-system/internal/pkg/mq"
 	redisPkg "seckill-system/internal/pkg/redis"
+	"seckill-system/internal/pkg/scheduler"
 	"seckill-system/internal/service"
 	"seckill-system/internal/utils"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,6 +34,8 @@ func main() {
 	if err := viper.ReadInConfig(); err != nil {
 		panic(fmt.Errorf("fatal error config file: %s", err))
 	}
+	// 管理接口令牌不写进配置文件时可用环境变量提供
+	viper.BindEnv("admin.token", "SECKILL_ADMIN_TOKEN")
 
 	//初始化数据库
 	db := database.InitMySQL()
@@ -32,25 +45,59 @@ func main() {
 	mqPkg.InitRabbitMQ()
 	defer mqPkg.Close()
 
+	//后台任务选主：对账、租约回收等只在 leader 上执行
+	elector := lock.NewElector("leader:jobs", viper.GetDuration("seckill.leader.ttl"))
+	elector.Start()
+
 	//启动订单消费者
 	orderConsumer := &service.OrderConsumer{
 		DB: db,
 	}
 	orderConsumer.Start()
 
+	//启动订单队列巡检（削峰）
+	loadShedder := service.NewLoadShedder()
+	loadShedder.Start()
+	defer loadShedder.Stop()
+
+	//本地售罄标记，各实例通过 Redis pub/sub 同步
+	soldOutCache := service.NewSoldOutCache()
+	soldOutCache.Subscribe()
+
 	// 初始化 Gin
 	r := gin.Default()
 
 	//创建Product处理器实例
+	productCache := service.NewProductCache()
 	productService := &service.ProductService{
-		DB: db,
+		DB:    db,
+		Cache: productCache,
 	}
 	//启动回灌：DB-->Redis库存协程
-	if err := productService.SyncStockToRedis(); err != nil {
-		panic(fmt.Errorf("sync stock to redis failed: %s", err))
+	if viper.GetBool("seckill.startup.rebuild_redis") {
+		// Redis 数据丢失后：从订单表重建购买标记并覆盖库存
+		if _, err := productService.RebuildRedis(); err != nil && err != lock.ErrNotAcquired {
+			panic(fmt.Errorf("rebuild redis failed: %s", err))
+		}
+	} else {
+		// 多实例同时启动时只由抢到锁的实例回灌
+		_, err := productService.SyncStock(viper.GetBool("seckill.startup.authoritative_sync"))
+		if err == lock.ErrNotAcquired {
+			log.Println("Stock sync is running on another instance, skipped")
+		} else if err != nil {
+			panic(fmt.Errorf("sync stock to redis failed: %s", err))
+		}
 	}
+	inventoryService := &service.InventoryService{
+		DB:      db,
+		SoldOut: soldOutCache,
+		Cache:   productCache,
+	}
+	waveService := service.NewWaveService(db, inventoryService)
+
 	productHandler := &handler.ProductHandler{
 		ProductService: productService,
+		WaveService:    waveService,
 	}
 
 	//创建User处理器实例
@@ -62,6 +109,17 @@ func main() {
 	r.POST("/register", userHandler.Register)
 	r.POST("/login", userHandler.Login)
 
+	orderService := &service.OrderService{
+		DB:      db,
+		SoldOut: soldOutCache,
+	}
+	orderHandler := &handler.OrderHandler{
+		OrderService: orderService,
+	}
+	lotteryHandler := &handler.LotteryHandler{
+		LotteryService: &service.LotteryService{DB: db},
+	}
+
 	//需要认证的路由
 	auth := r.Group("/user")
 	auth.Use(middleware.Auth())
@@ -70,15 +128,138 @@ func main() {
 			uid := c.GetUint("uid")
 			c.JSON(200, gin.H{"uid": uid})
 		})
+		auth.GET("/orders", orderHandler.ListMine)
+		auth.GET("/orders/:id", orderHandler.GetMine)
+		auth.POST("/lotteries/:id/entries", lotteryHandler.Enter)
+		auth.GET("/lotteries/:id/result", lotteryHandler.Result)
 	}
 
 	//秒杀相关路由
-	SeckillService := &service.SeckillService{}
+	stockLeaser := service.NewStockLeaser(soldOutCache)
+	stockLeaser.Start()
+	SeckillService := &service.SeckillService{
+		SoldOut: soldOutCache,
+		Leaser:  stockLeaser,
+	}
+
+	//库存对账
+	reconciler := service.NewStockReconciler(db, soldOutCache)
+
+	//定时任务：leader-only 的任务只在 leader 实例上执行
+	jobs := scheduler.New(elector)
+	if reconciler.Enabled {
+		mustAddJob(jobs, scheduler.Job{
+			Name:       "stock-reconcile",
+			Every:      reconciler.Interval,
+			Cron:       reconciler.Cron,
+			Timeout:    5 * time.Minute,
+			LeaderOnly: true,
+			Run:        reconciler.RunScheduled,
+		})
+	}
+	if stockLeaser.Enabled {
+		mustAddJob(jobs, scheduler.Job{
+			Name:       "stock-lease-reclaim",
+			Every:      10 * time.Second,
+			Timeout:    30 * time.Second,
+			LeaderOnly: true,
+			Run: func(ctx context.Context) error {
+				_, err := stockLeaser.ReclaimOrphans()
+				return err
+			},
+		})
+	}
+	//排队放行
+	waitingRoom, err := service.NewWaitingRoom()
+	if err != nil {
+		log.Fatalf("Failed to init waiting room: %v", err)
+	}
+	if waitingRoom.Enabled {
+		mustAddJob(jobs, scheduler.Job{
+			Name:       "waiting-room-admit",
+			Every:      waitingRoom.Interval,
+			Timeout:    10 * time.Second,
+			LeaderOnly: true,
+			Run:        waitingRoom.Admit,
+		})
+	}
+	if waveService.Enabled {
+		mustAddJob(jobs, scheduler.Job{
+			Name:       "stock-waves",
+			Every:      waveService.Interval,
+			Timeout:    30 * time.Second,
+			LeaderOnly: true,
+			Run:        waveService.ReleaseDue,
+		})
+	}
+	jobs.Start()
+
+	waitingRoomHandler := &handler.WaitingRoomHandler{WaitingRoom: waitingRoom}
+	auth.POST("/waiting-room/:id", waitingRoomHandler.Join)
+	auth.GET("/waiting-room/:id", waitingRoomHandler.Position)
+
+	campaignHandler := &handler.CampaignHandler{
+		CampaignService: &service.CampaignService{DB: db},
+		WaveService:     waveService,
+	}
+	auth.POST("/campaign-groups/:id/reservation", campaignHandler.Reserve)
+	auth.GET("/campaign-groups/:id/reservation", campaignHandler.Reservation)
+	auth.DELETE("/campaign-groups/:id/reservation", campaignHandler.CancelReservation)
+	skuHandler := &handler.SKUHandler{
+		SKUService: &service.SKUService{DB: db, Cache: productCache},
+	}
+	bundleHandler := &handler.BundleHandler{
+		BundleService: &service.BundleService{DB: db, Cache: productCache},
+	}
+	adminHandler := &handler.AdminHandler{
+		Reconciler:       reconciler,
+		ProductService:   productService,
+		InventoryService: inventoryService,
+		OrderService:     orderService,
+		Scheduler:        jobs,
+	}
+
+	//管理接口
+	admin := r.Group("/admin")
+	admin.Use(middleware.AdminAuth())
+	{
+		admin.GET("/stock/reconcile", adminHandler.LastReconcile)
+		admin.POST("/stock/reconcile", adminHandler.Reconcile)
+		admin.POST("/stock/sync", adminHandler.SyncStock)
+		admin.POST("/redis/rebuild", adminHandler.RebuildRedis)
+		admin.GET("/jobs", adminHandler.Jobs)
+		admin.PUT("/products/:id", productHandler.Update)
+		admin.PUT("/products/:id/status", productHandler.SetStatus)
+		admin.DELETE("/products/:id", productHandler.Delete)
+		admin.POST("/products/:id/stock", adminHandler.AdjustStock)
+		admin.GET("/products/:id/ledger", adminHandler.Ledger)
+		admin.POST("/products/:id/skus", skuHandler.Create)
+		admin.POST("/bundles", bundleHandler.Create)
+		admin.POST("/lotteries", lotteryHandler.Create)
+		admin.POST("/lotteries/:id/draw", lotteryHandler.Draw)
+		admin.GET("/lotteries/:id/audit", lotteryHandler.Audit)
+		admin.POST("/campaign-groups", campaignHandler.Create)
+		admin.GET("/campaign-groups", campaignHandler.List)
+		admin.GET("/campaign-groups/:id", campaignHandler.Get)
+		admin.PUT("/campaign-groups/:id", campaignHandler.Update)
+		admin.GET("/campaign-groups/:id/waves", campaignHandler.Waves)
+		admin.PUT("/campaign-groups/:id/waves", campaignHandler.SetWaves)
+		admin.GET("/campaign-groups/:id/stats", campaignHandler.Stats)
+		admin.GET("/campaign-groups/:id/reservations", campaignHandler.ExportReservations)
+		admin.GET("/orders", orderHandler.List)
+		admin.GET("/orders/:id", orderHandler.Get)
+		admin.POST("/orders/:id/cancel", adminHandler.CancelOrder)
+	}
 
 	r.POST("/product", productHandler.Create)
 	r.GET("/products", productHandler.List)
+	r.GET("/products/:id", productHandler.Get)
+	r.GET("/products/:id/skus", skuHandler.List)
+	r.GET("/bundles/:id", bundleHandler.Get)
+	r.GET("/products/:id/next-wave", productHandler.NextWave)
+	r.GET("/lotteries/:id", lotteryHandler.Get)
 
-	auth.POST("/seckill/:id", func(c *gin.Context) {
+	auth.POST("/seckill/:id", middleware.LoadShed(loadShedder), middleware.RequireAdmission(waitingRoom), func(c *gin.Context) {
 		uid := c.GetUint("uid")
 		id := utils.StrToUint(c.Param("id"))
 		if id == 0 {
@@ -86,7 +267,31 @@ func main() {
 			return
 		}
 
-		err := SeckillService.StartSeckill(id, uid)
+		// 请求体可选：{"sku_id": id, "quantity": n}，默认 1 件；有规格的商品必须带 sku_id
+		var req struct {
+			SKUID    uint `json:"sku_id"`
+			Quantity int  `json:"quantity"`
+		}
+		req.Quantity = 1
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(400, gin.H{"error": "bad request"})
+			return
+		}
+
+		err := SeckillService.StartSeckill(id, req.SKUID, uid, req.Quantity)
+		if errors.Is(err, service.ErrProductNotFound) || errors.Is(err, service.ErrSKUNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrProductOffShelf) || errors.Is(err, service.ErrLotteryOnly) ||
+			errors.Is(err, service.ErrReservationRequired) {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(400, gin.H{"error": err.Error()})
 			return
@@ -127,6 +332,9 @@ func main() {
 		}
 		health["rabbitmq"] = "ok"
 
+		// 削峰状态（不影响健康状态）
+		health["load_shedding"] = loadShedder.State()
+
 		c.JSON(200, health)
 	})
 
@@ -149,6 +357,21 @@ func main() {
 			"idle":             dbStats.Idle,
 		}
 
+		// 订单队列削峰状态
+		stats["load_shedding"] = loadShedder.State()
+
+		// 本地售罄标记
+		stats["sold_out_products"] = soldOutCache.Snapshot()
+
+		// 商品目录缓存命中情况
+		stats["product_cache"] = productCache.Stats()
+
+		// 后台任务选主状态
+		stats["leader"] = elector.Status()
+
+		// 业务计数（对账差异等）
+		stats["metrics"] = metrics.Snapshot()
+
 		c.JSON(200, stats)
 	})
 
@@ -158,5 +381,34 @@ func main() {
 	fmt.Println("   Stats:  http://localhost:8080/stats")
 
 	// 启动服务器
-	r.Run(":8080")
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: r,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("listen: %s", err)
+		}
+	}()
+
+	// 优雅退出：停止接收请求后归还租约库存
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+	jobs.Stop(ctx)
+	stockLeaser.Stop()
+	elector.Stop()
+}
+
+func mustAddJob(s *scheduler.Scheduler, job scheduler.Job) {
+	if err := s.Add(job); err != nil {
+		panic(fmt.Errorf("add job %s failed: %s", job.Name, err))
+	}
 }
@@ -3,7 +3,9 @@ package database
 import (
 	"fmt"
 	"log"
+	"math"
 	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/money"
 	"time"
 
 	"github.com/spf13/viper"
@@ -61,9 +63,62 @@ func InitMySQL() *gorm.DB {
 	log.Println("   - Max Idle Conns: 10")
 	log.Println("   - Conn Max Lifetime: 1h")
 
+	// 累计入库量列首次创建时需要回填
+	backfillTotalStock := !db.Migrator().HasColumn(&model.Product{}, "total_stock")
+
 	// 自动迁移表结构
 	db.AutoMigrate(&model.User{})
 	db.AutoMigrate(&model.Product{})
 	db.AutoMigrate(&model.Order{})
+	db.AutoMigrate(&model.InventoryLedger{})
+	db.AutoMigrate(&model.StockRepair{})
+	db.AutoMigrate(&model.CampaignGroup{})
+	db.AutoMigrate(&model.CampaignReservation{})
+	db.AutoMigrate(&model.SKU{})
+	db.AutoMigrate(&model.BundleItem{})
+	db.AutoMigrate(&model.OrderItem{})
+	db.AutoMigrate(&model.StockWave{})
+	db.AutoMigrate(&model.Lottery{})
+	db.AutoMigrate(&model.LotteryEntry{})
+	migrateProductPrice(db)
+
+	if backfillTotalStock {
+		migrateTotalStock(db)
+	}
 	return db
 }
+
+// 回填累计入库量：现有库存 + 未取消订单件数，订单口径与对账一致
+// （规格订单、组合商品订单不计入商品本身，组合商品的组件按订单明细计入）
+func migrateTotalStock(db *gorm.DB) {
+	err := db.Exec(`UPDATE products p SET total_stock = stock +
+		(SELECT COALESCE(SUM(o.quantity), 0) FROM orders o
+			WHERE o.product_id = p.id AND o.status <> 'cancelled' AND o.sku_id IS NULL AND o.bundle = false) +
+		(SELECT COALESCE(SUM(i.quantity), 0) FROM order_items i JOIN orders o ON o.id = i.order_id
+			WHERE i.product_id = p.id AND o.status <> 'cancelled')
+		WHERE total_stock = 0`).Error
+	if err != nil {
+		log.Printf("⚠️ Failed to backfill products.total_stock: %v", err)
+		return
+	}
+	log.Println("✅ Backfilled products.total_stock")
+}
+
+// 旧版 products.price 为浮点数，按默认币种换算成最小货币单位后删除旧列
+func migrateProductPrice(db *gorm.DB) {
+	if !db.Migrator().HasColumn("products", "price") {
+		return
+	}
+	exp, _ := money.Exponent(money.DefaultCurrency)
+	err := db.Exec("UPDATE products SET price_amount = ROUND(price * ?), price_currency = ? WHERE price_amount = 0",
+		math.Pow10(exp), money.DefaultCurrency).Error
+	if err != nil {
+		log.Printf("⚠️ Failed to migrate products.price: %v", err)
+		return
+	}
+	if err := db.Migrator().DropColumn("products", "price"); err != nil {
+		log.Printf("⚠️ Failed to drop products.price: %v", err)
+		return
+	}
+	log.Println("✅ Migrated products.price to price_amount/price_currency")
+}
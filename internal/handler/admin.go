@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"seckill-system/internal/pkg/lock"
+	"seckill-system/internal/pkg/scheduler"
+	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	Reconciler       *service.StockReconciler
+	ProductService   *service.ProductService
+	InventoryService *service.InventoryService
+	OrderService     *service.OrderService
+	Scheduler        *scheduler.Scheduler
+}
+
+// 立即执行库存对账，?repair=true 时修复 Redis 库存
+func (h *AdminHandler) Reconcile(c *gin.Context) {
+	repair := c.Query("repair") == "true"
+	report, err := h.Reconciler.Run(c.Request.Context(), repair)
+	if err == lock.ErrNotAcquired {
+		c.JSON(http.StatusConflict, gin.H{"error": "reconciliation already running"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// 最近一次对账结果
+func (h *AdminHandler) LastReconcile(c *gin.Context) {
+	report := h.Reconciler.Last()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no reconciliation yet"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// 回灌库存到 Redis，?authoritative=true 时以 MySQL 为准覆盖
+func (h *AdminHandler) SyncStock(c *gin.Context) {
+	report, err := h.ProductService.SyncStock(c.Query("authoritative") == "true")
+	if err == lock.ErrNotAcquired {
+		c.JSON(http.StatusConflict, gin.H{"error": "stock sync already running"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// 从订单表重建购买标记，并以 MySQL 为准重置 Redis 库存
+func (h *AdminHandler) RebuildRedis(c *gin.Context) {
+	report, err := h.ProductService.RebuildRedis()
+	if err == lock.ErrNotAcquired {
+		c.JSON(http.StatusConflict, gin.H{"error": "rebuild already running"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// 定时任务列表：上次执行时间、耗时、结果与下次执行时间
+func (h *AdminHandler) Jobs(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Scheduler.Status())
+}
+
+// 补货或调整库存：mode=add 增加 quantity 件，mode=set 设置为 quantity 件；带 sku_id 时调整该规格
+func (h *AdminHandler) AdjustStock(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+
+	var req struct {
+		SKUID    uint   `json:"sku_id"`
+		Mode     string `json:"mode"`
+		Quantity int    `json:"quantity"`
+		Reason   string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+
+	adj, err := h.InventoryService.AdjustStock(id, req.SKUID, req.Mode, req.Quantity, req.Reason, adminActor(c))
+	switch {
+	case errors.Is(err, service.ErrProductNotFound), errors.Is(err, service.ErrSKUNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrOutOfStock), errors.Is(err, service.ErrStockNotSynced):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, adj)
+	}
+}
+
+// 库存流水：?page=&size=&type=&order_id=&sku_id=&since=&until=（时间为 RFC3339）
+func (h *AdminHandler) Ledger(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+
+	q := service.LedgerQuery{
+		Type:    c.Query("type"),
+		OrderID: utils.StrToUint(c.Query("order_id")),
+		SKUID:   utils.StrToUint(c.Query("sku_id")),
+		Page:    int(utils.StrToUint(c.Query("page"))),
+		Size:    int(utils.StrToUint(c.Query("size"))),
+	}
+	var err error
+	if v := c.Query("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid until"})
+			return
+		}
+	}
+
+	page, err := h.InventoryService.Ledger(id, q)
+	if errors.Is(err, service.ErrProductNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// 取消订单并退回库存
+func (h *AdminHandler) CancelOrder(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// 请求体可选
+	_ = c.ShouldBindJSON(&req)
+
+	order, err := h.OrderService.Cancel(id, adminActor(c), req.Reason)
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrOrderCancelled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// 操作人：请求头 X-Admin-User，未提供时记为 admin
+func adminActor(c *gin.Context) string {
+	if user := c.GetHeader("X-Admin-User"); user != "" {
+		return user
+	}
+	return "admin"
+}
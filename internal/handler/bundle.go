@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"seckill-system/internal/pkg/money"
+	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BundleHandler struct {
+	BundleService *service.BundleService
+}
+
+// 新建组合商品：{"name", "price", "currency", "seckill_price", "purchase_limit", "items": [{"product_id", "quantity"}]}
+// 建好后按普通商品的ID秒杀，一次扣减全部组件
+func (h *BundleHandler) Create(c *gin.Context) {
+	var req struct {
+		Name          string                    `json:"name"`
+		Price         json.RawMessage           `json:"price"`
+		Currency      string                    `json:"currency"`
+		SeckillPrice  json.RawMessage           `json:"seckill_price"`
+		PurchaseLimit int                       `json:"purchase_limit"`
+		Items         []service.BundleComponent `json:"items"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if req.PurchaseLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purchase_limit must not be negative"})
+		return
+	}
+	price, err := parsePrice(req.Price, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var seckillPrice money.Money
+	if len(req.SeckillPrice) > 0 && string(req.SeckillPrice) != "null" {
+		if seckillPrice, err = parsePrice(req.SeckillPrice, price.Currency); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seckill_price: " + err.Error()})
+			return
+		}
+	}
+
+	bundle, err := h.BundleService.Create(service.BundleParams{
+		Name:          req.Name,
+		Price:         price,
+		SeckillPrice:  seckillPrice,
+		PurchaseLimit: req.PurchaseLimit,
+		Items:         req.Items,
+	})
+	if err != nil {
+		c.JSON(bundleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, bundle)
+}
+
+// 组合商品详情：组件及其实时库存，stock 为可售套数
+func (h *BundleHandler) Get(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bundle id"})
+		return
+	}
+	bundle, err := h.BundleService.Get(id)
+	if err != nil {
+		c.JSON(bundleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, bundle)
+}
+
+func bundleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrProductNotFound), errors.Is(err, service.ErrNotBundle):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidBundle), errors.Is(err, service.ErrInvalidPrice):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CampaignHandler struct {
+	CampaignService *service.CampaignService
+	WaveService     *service.WaveService
+}
+
+// 创建活动组：max_units 每人组内累计件数，max_orders_per_day 每人每天下单次数，0 不限；
+// require_reservation 开启后只有预约过的用户可以秒杀，reserve_until 为预约截止时间（RFC3339，可选）
+func (h *CampaignHandler) Create(c *gin.Context) {
+	var req struct {
+		Name               string     `json:"name"`
+		MaxUnits           int        `json:"max_units"`
+		MaxOrdersPerDay    int        `json:"max_orders_per_day"`
+		RequireReservation bool       `json:"require_reservation"`
+		ReserveUntil       *time.Time `json:"reserve_until"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if req.MaxUnits < 0 || req.MaxOrdersPerDay < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limits must not be negative"})
+		return
+	}
+
+	group, err := h.CampaignService.Create(service.CampaignGroupParams{
+		Name:               req.Name,
+		MaxUnits:           req.MaxUnits,
+		MaxOrdersPerDay:    req.MaxOrdersPerDay,
+		RequireReservation: req.RequireReservation,
+		ReserveUntil:       req.ReserveUntil,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+func (h *CampaignHandler) List(c *gin.Context) {
+	groups, err := h.CampaignService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+func (h *CampaignHandler) Get(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	group, err := h.CampaignService.Get(id)
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// 修改活动组名称或限制，省略的字段保持不变
+func (h *CampaignHandler) Update(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	var req struct {
+		Name               *string    `json:"name"`
+		MaxUnits           *int       `json:"max_units"`
+		MaxOrdersPerDay    *int       `json:"max_orders_per_day"`
+		RequireReservation *bool      `json:"require_reservation"`
+		ReserveUntil       *time.Time `json:"reserve_until"` // "0001-01-01T00:00:00Z" 取消截止时间
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+		return
+	}
+	if (req.MaxUnits != nil && *req.MaxUnits < 0) || (req.MaxOrdersPerDay != nil && *req.MaxOrdersPerDay < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limits must not be negative"})
+		return
+	}
+
+	group, err := h.CampaignService.Update(id, service.CampaignGroupUpdate{
+		Name:            req.Name,
+		MaxUnits:        req.MaxUnits,
+		MaxOrdersPerDay: req.MaxOrdersPerDay,
+
+		RequireReservation: req.RequireReservation,
+		ReserveUntil:       req.ReserveUntil,
+	})
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// 当前用户预约活动，重复预约返回原记录
+func (h *CampaignHandler) Reserve(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	r, err := h.CampaignService.Reserve(id, c.GetUint("uid"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// 查询当前用户的预约
+func (h *CampaignHandler) Reservation(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	r, err := h.CampaignService.Reservation(id, c.GetUint("uid"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+func (h *CampaignHandler) CancelReservation(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	if err := h.CampaignService.CancelReservation(id, c.GetUint("uid")); err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "reservation cancelled"})
+}
+
+// 活动统计：预约人数、商品数、订单数、件数、购买人数
+func (h *CampaignHandler) Stats(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	stats, err := h.CampaignService.Stats(id)
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// 导出预约用户 CSV
+func (h *CampaignHandler) ExportReservations(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	if _, err := h.CampaignService.Get(id); err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="campaign-%d-reservations.csv"`, id))
+	// 已开始写响应体，出错只能记日志
+	if err := h.CampaignService.ExportReservations(id, c.Writer); err != nil {
+		log.Printf("⚠️ [导出预约用户失败]: groupID=%d, err=%v", id, err)
+	}
+}
+
+// 设置放量计划：{"waves": [{"product_id", "release_at"(RFC3339), "quantity"}]}，
+// 替换尚未放出的批次，传空数组取消全部待放批次
+func (h *CampaignHandler) SetWaves(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	var req struct {
+		Waves []service.WaveParams `json:"waves"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	waves, err := h.WaveService.SetWaves(id, req.Waves)
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"waves": waves})
+}
+
+// 放量计划：全部批次及每个商品的下一批
+func (h *CampaignHandler) Waves(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign group id"})
+		return
+	}
+	if _, err := h.CampaignService.Get(id); err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	waves, err := h.WaveService.List(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	next, err := h.WaveService.NextWaves(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"waves": waves, "next": next})
+}
+
+func campaignErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrCampaignGroupNotFound), errors.Is(err, service.ErrNotReserved):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrReservationClosed):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidWave):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
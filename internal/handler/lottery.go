@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LotteryHandler struct {
+	LotteryService *service.LotteryService
+}
+
+// 新建抽签：{"product_id", "name", "entry_start", "entry_end"}（时间为 RFC3339）
+func (h *LotteryHandler) Create(c *gin.Context) {
+	var req service.LotteryParams
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" || req.ProductID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and product_id are required"})
+		return
+	}
+	lottery, err := h.LotteryService.Create(req)
+	if err != nil {
+		c.JSON(lotteryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lottery)
+}
+
+func (h *LotteryHandler) Get(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lottery id"})
+		return
+	}
+	lottery, err := h.LotteryService.Get(id)
+	if err != nil {
+		c.JSON(lotteryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lottery)
+}
+
+// 当前用户报名
+func (h *LotteryHandler) Enter(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lottery id"})
+		return
+	}
+	if err := h.LotteryService.Enter(id, c.GetUint("uid")); err != nil {
+		c.JSON(lotteryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "报名成功"})
+}
+
+// 当前用户的抽签结果
+func (h *LotteryHandler) Result(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lottery id"})
+		return
+	}
+	res, err := h.LotteryService.Result(id, c.GetUint("uid"))
+	if err != nil {
+		c.JSON(lotteryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// 开奖，请求体可选：{"seed": "..."}，不传时随机生成，开奖后公开
+func (h *LotteryHandler) Draw(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lottery id"})
+		return
+	}
+	var req struct {
+		Seed string `json:"seed"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	lottery, err := h.LotteryService.Draw(id, req.Seed, adminActor(c))
+	if err != nil {
+		c.JSON(lotteryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lottery)
+}
+
+// 开奖审计：种子、规则与按排名列出的全部报名
+func (h *LotteryHandler) Audit(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lottery id"})
+		return
+	}
+	audit, err := h.LotteryService.Audit(id)
+	if err != nil {
+		c.JSON(lotteryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, audit)
+}
+
+func lotteryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrLotteryNotFound), errors.Is(err, service.ErrProductNotFound),
+		errors.Is(err, service.ErrNotEntered):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrLotteryNotOpen), errors.Is(err, service.ErrLotteryNotClosed),
+		errors.Is(err, service.ErrLotteryDrawn), errors.Is(err, service.ErrLotteryNotDrawn),
+		errors.Is(err, service.ErrAlreadyEntered), errors.Is(err, service.ErrLotteryDrawing):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidLottery):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
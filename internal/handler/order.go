@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrderHandler struct {
+	OrderService *service.OrderService
+}
+
+// 当前用户的订单：?page=&size=&status=
+func (h *OrderHandler) ListMine(c *gin.Context) {
+	h.list(c, c.GetUint("uid"))
+}
+
+// 当前用户的单个订单，他人订单按不存在处理
+func (h *OrderHandler) GetMine(c *gin.Context) {
+	order, ok := h.get(c)
+	if !ok {
+		return
+	}
+	if order.UserID != c.GetUint("uid") {
+		c.JSON(http.StatusNotFound, gin.H{"error": service.ErrOrderNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// 管理员查询订单：?user_id=&product_id=&status=&page=&size=
+func (h *OrderHandler) List(c *gin.Context) {
+	h.list(c, utils.StrToUint(c.Query("user_id")))
+}
+
+func (h *OrderHandler) Get(c *gin.Context) {
+	if order, ok := h.get(c); ok {
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+func (h *OrderHandler) list(c *gin.Context, userID uint) {
+	page, err := h.OrderService.List(service.OrderQuery{
+		UserID:    userID,
+		ProductID: utils.StrToUint(c.Query("product_id")),
+		Status:    c.Query("status"),
+		Page:      int(utils.StrToUint(c.Query("page"))),
+		Size:      int(utils.StrToUint(c.Query("size"))),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *OrderHandler) get(c *gin.Context) (*service.OrderView, bool) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+		return nil, false
+	}
+	order, err := h.OrderService.Get(id)
+	if errors.Is(err, service.ErrOrderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return order, true
+}
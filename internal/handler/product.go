@@ -1,30 +1,83 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/money"
 	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ProductHandler struct {
 	ProductService *service.ProductService
+	WaveService    *service.WaveService
 }
 
 // 创建产品
 func (h *ProductHandler) Create(c *gin.Context) {
 	var req struct {
-		Name  string  `json:"name"`
-		Stock int     `json:"stock"`
-		Price float64 `json:"price"`
+		Name     string          `json:"name"`
+		Stock    int             `json:"stock"`
+		Price    json.RawMessage `json:"price"`    // 十进制字符串（推荐）或数字，如 "12.34"
+		Currency string          `json:"currency"` // 可选，默认 CNY
+		Shards   int             `json:"shards"`   // 可选，热点商品的库存分片数
+
+		SeckillPrice  json.RawMessage `json:"seckill_price"`  // 可选，秒杀价，与 price 同币种
+		PurchaseLimit int             `json:"purchase_limit"` // 可选，每人限购件数，默认读取配置
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
 		return
 	}
+	if strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if req.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stock must not be negative"})
+		return
+	}
+	if req.Shards < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shards must not be negative"})
+		return
+	}
+	if req.PurchaseLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purchase_limit must not be negative"})
+		return
+	}
+	price, err := parsePrice(req.Price, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	err := h.ProductService.Create(req.Name, req.Stock, req.Price)
+	var seckillPrice money.Money
+	if len(req.SeckillPrice) > 0 && string(req.SeckillPrice) != "null" {
+		if seckillPrice, err = parsePrice(req.SeckillPrice, price.Currency); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seckill_price: " + err.Error()})
+			return
+		}
+	}
+
+	err = h.ProductService.Create(service.ProductParams{
+		Name:          req.Name,
+		Stock:         req.Stock,
+		Price:         price,
+		SeckillPrice:  seckillPrice,
+		Shards:        req.Shards,
+		PurchaseLimit: req.PurchaseLimit,
+	}, adminActor(c))
+	if errors.Is(err, service.ErrInvalidPrice) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -33,12 +86,224 @@ func (h *ProductHandler) Create(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "product created successfully"})
 }
 
-// 列出所有产品
+// 产品列表：?page=&size= 或 ?cursor=&size= 翻页，
+// 过滤 name、min_price、max_price（币种 currency，默认 CNY）、in_stock=true、status，排序 sort=price|-price|created_at|-created_at
 func (h *ProductHandler) List(c *gin.Context) {
-	products, err := h.ProductService.List()
+	q := service.ProductQuery{
+		Name:    c.Query("name"),
+		InStock: c.Query("in_stock") == "true",
+		Status:  c.Query("status"),
+		Sort:    c.Query("sort"),
+		Cursor:  c.Query("cursor"),
+		Page:    int(utils.StrToUint(c.Query("page"))),
+		Size:    int(utils.StrToUint(c.Query("size"))),
+	}
+	for param, dst := range map[string]**money.Money{"min_price": &q.MinPrice, "max_price": &q.MaxPrice} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		price, err := money.Parse(v, c.Query("currency"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param})
+			return
+		}
+		*dst = &price
+	}
+
+	page, err := h.ProductService.List(q)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidQuery) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, page)
+}
+
+// 获取单个产品
+func (h *ProductHandler) Get(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+
+	product, err := h.ProductService.Detail(id)
+	if err != nil {
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, product)
+}
+
+// 商品的下一批放量时间与件数，没有待放批次时 next_wave 为 null
+func (h *ProductHandler) NextWave(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+	if _, err := h.ProductService.Cached(id); err != nil {
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	wave, err := h.WaveService.NextWave(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(200, products)
+	c.JSON(http.StatusOK, gin.H{"product_id": id, "next_wave": wave})
+}
+
+// 修改产品名称、价格
+func (h *ProductHandler) Update(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+
+	var req struct {
+		Name     *string         `json:"name"`
+		Price    json.RawMessage `json:"price"`
+		Currency string          `json:"currency"` // 随 price 一起修改，默认 CNY
+
+		SeckillPrice  json.RawMessage `json:"seckill_price"`  // "0" 表示取消秒杀价
+		PurchaseLimit *int            `json:"purchase_limit"` // 0 表示恢复默认
+
+		CampaignGroupID *uint `json:"campaign_group_id"` // 0 表示移出活动组
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+		return
+	}
+	if req.PurchaseLimit != nil && *req.PurchaseLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purchase_limit must not be negative"})
+		return
+	}
+	var price *money.Money
+	if len(req.Price) > 0 && string(req.Price) != "null" {
+		p, err := parsePrice(req.Price, req.Currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		price = &p
+	} else if req.Currency != "" && len(req.SeckillPrice) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency can only be changed together with price"})
+		return
+	}
+
+	var seckillPrice *money.Money
+	if len(req.SeckillPrice) > 0 && string(req.SeckillPrice) != "null" {
+		// 与 Create 一致按商品价格的币种解析：同时改价时用新价格的币种，否则用库中的币种
+		currency := req.Currency
+		if price != nil {
+			currency = price.Currency
+		} else if currency == "" {
+			product, err := h.ProductService.Get(id)
+			if err != nil {
+				c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			currency = product.Price.Currency
+		}
+		p, err := parsePrice(req.SeckillPrice, currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seckill_price: " + err.Error()})
+			return
+		}
+		seckillPrice = &p
+	}
+
+	product, err := h.ProductService.Update(id, service.ProductUpdate{
+		Name:            req.Name,
+		Price:           price,
+		SeckillPrice:    seckillPrice,
+		PurchaseLimit:   req.PurchaseLimit,
+		CampaignGroupID: req.CampaignGroupID,
+	})
+	if err != nil {
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, product)
+}
+
+// 上架/下架
+func (h *ProductHandler) SetStatus(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"` // on_shelf, off_shelf
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if req.Status != model.ProductOnShelf && req.Status != model.ProductOffShelf {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be on_shelf or off_shelf"})
+		return
+	}
+
+	product, err := h.ProductService.SetStatus(id, req.Status)
+	if err != nil {
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, product)
+}
+
+// 删除（归档）产品
+func (h *ProductHandler) Delete(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+
+	if err := h.ProductService.Delete(id); err != nil {
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "product deleted successfully"})
+}
+
+func productErrorStatus(err error) int {
+	if errors.Is(err, service.ErrProductNotFound) || errors.Is(err, service.ErrCampaignGroupNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, service.ErrInvalidPrice) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// 解析请求中的价格：接受 "12.34" 或 12.34，按原始文本解析，不经过浮点数
+func parsePrice(raw json.RawMessage, currency string) (money.Money, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return money.Money{}, errors.New("price is required")
+	}
+	text := string(raw)
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return money.Money{}, errors.New("invalid price")
+		}
+	}
+	price, err := money.Parse(text, currency)
+	if err != nil {
+		return money.Money{}, fmt.Errorf("price must be a non-negative decimal with at most the currency's minor digits: %w", err)
+	}
+	return price, nil
 }
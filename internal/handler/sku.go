@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"seckill-system/internal/pkg/money"
+	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SKUHandler struct {
+	SKUService *service.SKUService
+}
+
+// 新增规格：{"name", "stock", "price", "currency", "seckill_price"}
+func (h *SKUHandler) Create(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+	var req struct {
+		Name         string          `json:"name"`
+		Stock        int             `json:"stock"`
+		Price        json.RawMessage `json:"price"`
+		Currency     string          `json:"currency"`
+		SeckillPrice json.RawMessage `json:"seckill_price"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if req.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stock must not be negative"})
+		return
+	}
+	price, err := parsePrice(req.Price, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var seckillPrice money.Money
+	if len(req.SeckillPrice) > 0 && string(req.SeckillPrice) != "null" {
+		if seckillPrice, err = parsePrice(req.SeckillPrice, price.Currency); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seckill_price: " + err.Error()})
+			return
+		}
+	}
+
+	sku, err := h.SKUService.Create(id, service.SKUParams{
+		Name:         req.Name,
+		Stock:        req.Stock,
+		Price:        price,
+		SeckillPrice: seckillPrice,
+	}, adminActor(c))
+	if err != nil {
+		c.JSON(skuErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sku)
+}
+
+// 商品的规格列表，库存为实时可售库存
+func (h *SKUHandler) List(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+	items, err := h.SKUService.List(id)
+	if err != nil {
+		c.JSON(skuErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func skuErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrProductNotFound), errors.Is(err, service.ErrSKUNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrProductHasStock):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidPrice), errors.Is(err, service.ErrInvalidBundle):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"seckill-system/internal/service"
+	"seckill-system/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WaitingRoomHandler struct {
+	WaitingRoom *service.WaitingRoom
+}
+
+// 进入商品的排队队列，返回排队位置；已放行时返回放行令牌
+func (h *WaitingRoomHandler) Join(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+	st, err := h.WaitingRoom.Join(id, c.GetUint("uid"))
+	if err != nil {
+		c.JSON(waitingRoomErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// 查询当前排队位置
+func (h *WaitingRoomHandler) Position(c *gin.Context) {
+	id := utils.StrToUint(c.Param("id"))
+	if id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+	st, err := h.WaitingRoom.Position(id, c.GetUint("uid"))
+	if err != nil {
+		c.JSON(waitingRoomErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+func waitingRoomErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrProductNotFound), errors.Is(err, service.ErrNotInQueue):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrProductOffShelf), errors.Is(err, service.ErrLotteryOnly):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
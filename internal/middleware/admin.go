@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// 管理接口鉴权：请求头 X-Admin-Token 必须与配置 admin.token（或环境变量 SECKILL_ADMIN_TOKEN）一致，
+// 未配置时关闭管理接口
+func AdminAuth() gin.HandlerFunc {
+	if viper.GetString("admin.token") == "" {
+		log.Println("⚠️ admin.token is not set (config.yaml or SECKILL_ADMIN_TOKEN), admin api disabled")
+	}
+	return func(c *gin.Context) {
+		token := viper.GetString("admin.token")
+		if token == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin api disabled: set admin.token or SECKILL_ADMIN_TOKEN"})
+			c.Abort()
+			return
+		}
+
+		given := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"seckill-system/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdmissionVerifier interface {
+	UseAdmission(token string, userID, productID uint) error
+}
+
+// 校验并作废排队放行令牌（请求头 X-Admission-Token），需放在 Auth 之后
+func RequireAdmission(v AdmissionVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID := utils.StrToUint(c.Param("id"))
+		if err := v.UseAdmission(c.GetHeader("X-Admission-Token"), c.GetUint("uid"), productID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Shedder interface {
+	Shedding() bool
+	RetryAfterSeconds() int
+}
+
+// 队列积压时直接拒绝，返回 503 + Retry-After
+func LoadShed(s Shedder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.Shedding() {
+			c.Header("Retry-After", strconv.Itoa(s.RetryAfterSeconds()))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "system busy, please retry later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// 组合商品的组成：组合商品本身也是一个商品（不持有库存），
+// 秒杀一套时按 Quantity 同时扣减每个组件商品的库存
+type BundleItem struct {
+	ID        uint `gorm:"primaryKey"`
+	BundleID  uint `gorm:"not null;uniqueIndex:idx_bundle_product"` // 组合商品ID
+	ProductID uint `gorm:"not null;uniqueIndex:idx_bundle_product"` // 组件商品ID
+	Quantity  int  `gorm:"not null;default:1"`                      // 每套包含的件数
+	CreatedAt time.Time
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// 活动组：跨商品的用户限购，组内所有商品共用额度
+type CampaignGroup struct {
+	ID              uint   `gorm:"primaryKey"`
+	Name            string `gorm:"size:128;not null"`
+	MaxUnits        int    `gorm:"not null;default:0"` // 每人在组内累计最多购买件数，0 不限
+	MaxOrdersPerDay int    `gorm:"not null;default:0"` // 每人每天在组内最多下单次数，0 不限
+
+	RequireReservation bool       `gorm:"not null;default:false"` // 只有提前预约的用户可以秒杀组内商品
+	ReserveUntil       *time.Time // 预约截止时间，nil 不限
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// 活动预约记录，Redis 中 campaign:reserved:{gid} 是它的副本
+type CampaignReservation struct {
+	ID              uint `gorm:"primaryKey"`
+	CampaignGroupID uint `gorm:"not null;uniqueIndex:idx_campaign_user"`
+	UserID          uint `gorm:"not null;uniqueIndex:idx_campaign_user;index"`
+	CreatedAt       time.Time
+}
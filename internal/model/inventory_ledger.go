@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+const (
+	LedgerInit    = "init"    // 商品创建时的初始库存
+	LedgerSale    = "sale"    // 秒杀成交，订单落库时扣减
+	LedgerCancel  = "cancel"  // 订单取消，库存退回
+	LedgerRestock = "restock" // 补货（增加库存）
+	LedgerAdjust  = "adjust"  // 人工调整（设置库存）
+)
+
+// 库存流水，只追加不修改
+type InventoryLedger struct {
+	ID        uint   `gorm:"primaryKey"`
+	ProductID uint   `gorm:"not null;index"`
+	SKUID     *uint  `gorm:"column:sku_id;index"` // 规格库存变动时记录规格
+	Type      string `gorm:"size:32;not null"`
+	Quantity  int    `gorm:"not null"` // 变动数量，增加为正、减少为负
+	Before    int    `gorm:"not null"` // 变动前 MySQL 库存
+	After     int    `gorm:"not null"` // 变动后 MySQL 库存
+	OrderID   *uint  `gorm:"index"`    // 成交、取消关联的订单
+	Actor     string `gorm:"size:64"`
+	Reason    string `gorm:"size:255"`
+	CreatedAt time.Time
+}
+
+func (InventoryLedger) TableName() string {
+	return "inventory_ledger"
+}
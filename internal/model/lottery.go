@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+const (
+	LotteryOpen    = "open"    // 报名中（或报名已截止、等待开奖）
+	LotteryDrawing = "drawing" // 开奖进行中
+	LotteryDrawn   = "drawn"   // 已开奖
+)
+
+const (
+	EntryPending    = "pending"    // 未开奖
+	EntryWon        = "won"        // 中签，已投递下单消息
+	EntryLost       = "lost"       // 未中签
+	EntryIneligible = "ineligible" // 排名靠前但超出限购，跳过
+	EntryFailed     = "failed"     // 中签但下单消息投递失败，名额已退回
+)
+
+// 抽签活动：报名窗口内登记意向，截止后用公开的种子抽签，中签者按库存生成订单
+type Lottery struct {
+	ID         uint      `gorm:"primaryKey"`
+	ProductID  uint      `gorm:"not null;index"`
+	Name       string    `gorm:"size:128;not null"`
+	EntryStart time.Time `gorm:"not null"`
+	EntryEnd   time.Time `gorm:"not null"`
+	Status     string    `gorm:"size:16;not null;default:'open'"`
+	Seed       string    `gorm:"size:64"` // 开奖种子，开奖后公开，任何人可据此复算结果
+	Winners    int       `gorm:"not null;default:0"`
+	DrawnAt    *time.Time
+	DrawnBy    string `gorm:"size:64"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type LotteryEntry struct {
+	ID        uint    `gorm:"primaryKey"`
+	LotteryID uint    `gorm:"not null;uniqueIndex:idx_lottery_user"`
+	UserID    uint    `gorm:"not null;uniqueIndex:idx_lottery_user;index"`
+	Result    string  `gorm:"size:16;not null;default:'pending'"`
+	RequestID *string `gorm:"size:64;uniqueIndex"` // 中签者的下单请求ID，据此关联订单
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -1,6 +1,10 @@
 package model
 
 type SeckillMessage struct {
-	UserID    uint `json:"user_id"`
-	ProductID uint `json:"product_id"`
+	UserID    uint   `json:"user_id"`
+	ProductID uint   `json:"product_id"`
+	SKUID     uint   `json:"sku_id,omitempty"` // 有规格的商品按规格扣库存
+	Bundle    bool   `json:"bundle,omitempty"` // 组合商品，消费者按组件逐个扣库存
+	Quantity  int    `json:"quantity"`         // 旧消息没有该字段，按 1 件处理
+	RequestID string `json:"request_id"`       // 每次抢购唯一，消费者据此去重
 }
@@ -1,12 +1,45 @@
 package model
 
-import "time"
+import (
+	"seckill-system/internal/pkg/money"
+	"time"
+)
+
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderCancelled = "cancelled"
+)
 
 type Order struct {
-	ID        uint   `gorm:"primaryKey"`
-	UserID    uint   `gorm:"not null;index"`    // 用户ID
-	ProductID uint   `gorm:"not null;index"`    // 商品ID
-	Status    string `gorm:"default:'pending'"` // pending, paid, cancelled
+	ID              uint    `gorm:"primaryKey"`
+	UserID          uint    `gorm:"not null;index"`         // 用户ID
+	ProductID       uint    `gorm:"not null;index"`         // 商品ID
+	SKUID           *uint   `gorm:"column:sku_id;index"`    // 购买的规格，无规格商品为空
+	Status          string  `gorm:"default:'pending'"`      // pending, paid, cancelled
+	RequestID       *string `gorm:"size:64;uniqueIndex"`    // 抢购请求ID，消息重复投递时去重
+	CampaignGroupID *uint   `gorm:"index"`                  // 下单时商品所属活动组，跨商品限购按此统计
+	Bundle          bool    `gorm:"not null;default:false"` // 组合商品订单，库存按 OrderItem 明细扣减
+
+	// 下单时的商品快照，商品之后改名、改价不影响已有订单
+	ProductName  string      `gorm:"size:255"`
+	SKUName      string      `gorm:"column:sku_name;size:128"`
+	Quantity     int         `gorm:"not null;default:1"`
+	UnitPrice    money.Money `gorm:"embedded;embeddedPrefix:unit_price_"`    // 原价
+	SeckillPrice money.Money `gorm:"embedded;embeddedPrefix:seckill_price_"` // 实际成交单价
+	Total        money.Money `gorm:"embedded;embeddedPrefix:total_"`         // 成交单价 × 数量
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+// 组合商品订单的明细，每个组件商品一行
+type OrderItem struct {
+	ID          uint        `gorm:"primaryKey"`
+	OrderID     uint        `gorm:"not null;index"`
+	ProductID   uint        `gorm:"not null;index"` // 组件商品ID
+	ProductName string      `gorm:"size:255"`
+	Quantity    int         `gorm:"not null"`                            // 组件件数 = 每套件数 × 购买套数
+	UnitPrice   money.Money `gorm:"embedded;embeddedPrefix:unit_price_"` // 下单时组件原价，仅供展示
+	CreatedAt   time.Time
+}
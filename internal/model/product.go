@@ -1,11 +1,37 @@
 package model
 
-import "time"
+import (
+	"seckill-system/internal/pkg/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ProductOnShelf  = "on_shelf"
+	ProductOffShelf = "off_shelf"
+)
 
 type Product struct {
-	ID        uint    `gorm:"primaryKey"`
-	Name      string  `gorm:"not null"`
-	Stock     int     `gorm:"not null"`
-	Price     float64 `gorm:"not null"`
-	CreatedAt time.Time
+	ID              uint        `gorm:"primaryKey"`
+	Name            string      `gorm:"not null"`
+	Stock           int         `gorm:"not null"`
+	TotalStock      int         `gorm:"not null;default:0"`                     // 累计入库量，对账用：Stock + 未取消订单数 应等于该值
+	Price           money.Money `gorm:"embedded;embeddedPrefix:price_"`         // 列 price_amount（最小货币单位）、price_currency
+	SeckillPrice    money.Money `gorm:"embedded;embeddedPrefix:seckill_price_"` // 秒杀价，金额为 0 表示按原价售卖
+	StockShards     int         `gorm:"not null;default:1"`                     // Redis 库存分片数，热点商品可拆成多个子key
+	PurchaseLimit   int         `gorm:"not null;default:0"`                     // 每人限购件数，0 表示使用默认配置
+	CampaignGroupID *uint       `gorm:"index"`                                  // 所属活动组，组内商品共用跨商品限购
+	Status          string      `gorm:"size:16;not null;default:'on_shelf'"`    // on_shelf, off_shelf
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt `gorm:"index"` // 删除即归档，历史订单仍可关联
+}
+
+// 成交单价：设置了同币种秒杀价时用秒杀价，否则用原价
+func (p *Product) ChargePrice() money.Money {
+	if p.SeckillPrice.Amount > 0 && p.SeckillPrice.Currency == p.Price.Currency {
+		return p.SeckillPrice
+	}
+	return p.Price
 }
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"seckill-system/internal/pkg/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 商品规格（如颜色、容量），有规格的商品按规格独立计库存和价格，商品本身不再持有库存
+type SKU struct {
+	ID           uint        `gorm:"primaryKey"`
+	ProductID    uint        `gorm:"not null;index"`
+	Name         string      `gorm:"size:128;not null"` // 规格名称，如 "黑色 256G"
+	Stock        int         `gorm:"not null"`
+	TotalStock   int         `gorm:"not null;default:0"` // 累计入库量
+	Price        money.Money `gorm:"embedded;embeddedPrefix:price_"`
+	SeckillPrice money.Money `gorm:"embedded;embeddedPrefix:seckill_price_"` // 金额为 0 表示按原价售卖
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
+}
+
+func (SKU) TableName() string {
+	return "skus"
+}
+
+// 成交单价：设置了同币种秒杀价时用秒杀价，否则用原价
+func (s *SKU) ChargePrice() money.Money {
+	if s.SeckillPrice.Amount > 0 && s.SeckillPrice.Currency == s.Price.Currency {
+		return s.SeckillPrice
+	}
+	return s.Price
+}
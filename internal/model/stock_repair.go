@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// 对账修复 Redis 库存的记录；Before/After 为 Redis 可售库存，与库存流水（MySQL 库存）分开保存
+type StockRepair struct {
+	ID        uint   `gorm:"primaryKey"`
+	ProductID uint   `gorm:"not null;index"`
+	Adjust    int64  `gorm:"not null"` // 对 Redis 库存的增减
+	Before    int64  `gorm:"not null"` // 修复前 Redis 可售库存
+	After     int64  `gorm:"not null"` // 修复后 Redis 可售库存
+	DBStock   int64  `gorm:"not null"` // 修复时的 MySQL 库存
+	Drift     int64  `gorm:"not null"` // 对账算出的差异
+	Actor     string `gorm:"size:64"`
+	CreatedAt time.Time
+}
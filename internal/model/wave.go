@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// 分批放量：到 ReleaseAt 时给活动组内的商品补 Quantity 件库存
+type StockWave struct {
+	ID              uint       `gorm:"primaryKey"`
+	CampaignGroupID uint       `gorm:"not null;index"`
+	ProductID       uint       `gorm:"not null;index:idx_wave_product_release"`
+	ReleaseAt       time.Time  `gorm:"not null;index:idx_wave_product_release"`
+	Quantity        int        `gorm:"not null"`
+	ReleasedAt      *time.Time `gorm:"index"` // 为空表示尚未放出
+	LedgerID        *uint      // 放量对应的库存流水
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
@@ -0,0 +1,131 @@
+package lock
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"seckill-system/internal/utils"
+)
+
+// 选主状态
+type ElectionStatus struct {
+	Name     string    `json:"name"`
+	Instance string    `json:"instance"`
+	IsLeader bool      `json:"is_leader"`
+	Leader   string    `json:"leader"`
+	Fence    int64     `json:"fence,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+// 基于租约锁的选主：持有锁的实例是 leader，定期续期，续期失败即让位
+type Elector struct {
+	Name string
+	TTL  time.Duration
+
+	mu    sync.RWMutex
+	lock  *Lock
+	since time.Time
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewElector(name string, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+	return &Elector{Name: name, TTL: ttl}
+}
+
+func (e *Elector) Start() {
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	e.tick()
+
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.TTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.tick()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止选主，是 leader 时主动释放，其他实例无需等锁过期
+func (e *Elector) Stop() {
+	if e.stop == nil {
+		return
+	}
+	close(e.stop)
+	<-e.done
+	e.stop = nil
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lock != nil {
+		e.lock.Release()
+		e.lock = nil
+		log.Printf("👑 [让出leader]: %s", e.Name)
+	}
+}
+
+func (e *Elector) tick() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock != nil {
+		ok, err := e.lock.Refresh(e.TTL)
+		if err == nil && ok {
+			return
+		}
+		log.Printf("⚠️ [失去leader]: %s, err=%v", e.Name, err)
+		e.lock = nil
+	}
+
+	l, err := Acquire(e.Name, e.TTL)
+	if err != nil {
+		return
+	}
+	e.lock = l
+	e.since = time.Now()
+	log.Printf("👑 [成为leader]: %s, instance=%s, fence=%d", e.Name, utils.InstanceID(), l.Fence)
+}
+
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lock != nil
+}
+
+// 当前任期的栅栏令牌，不是 leader 时返回 0
+func (e *Elector) Fence() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.lock == nil {
+		return 0
+	}
+	return e.lock.Fence
+}
+
+func (e *Elector) Status() ElectionStatus {
+	e.mu.RLock()
+	status := ElectionStatus{
+		Name:     e.Name,
+		Instance: utils.InstanceID(),
+		IsLeader: e.lock != nil,
+	}
+	if e.lock != nil {
+		status.Fence = e.lock.Fence
+		status.Since = e.since
+	}
+	e.mu.RUnlock()
+
+	status.Leader, _ = Holder(e.Name)
+	return status
+}
@@ -0,0 +1,116 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	redisPkg "seckill-system/internal/pkg/redis"
+	"seckill-system/internal/utils"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrNotAcquired = errors.New("lock not acquired")
+
+// 加锁成功后递增栅栏令牌（fencing token），返回 0 表示锁已被占用
+var acquireScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+// 只有持有者才能续期
+var refreshScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// 只有持有者才能释放
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// 基于 Redis 的租约锁，每次加锁得到单调递增的栅栏令牌；
+// 长任务在产生副作用前用 Valid 校验令牌，防止锁过期后旧持有者继续写入
+type Lock struct {
+	Name  string
+	Key   string
+	Token string
+	Fence int64
+}
+
+func Acquire(name string, ttl time.Duration) (*Lock, error) {
+	l := &Lock{
+		Name:  name,
+		Key:   "lock:" + name,
+		Token: newToken(),
+	}
+	fence, err := acquireScript.Run(redisPkg.Ctx, redisPkg.RDB,
+		[]string{l.Key, fenceKey(name)}, l.Token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if fence == 0 {
+		return nil, ErrNotAcquired
+	}
+	l.Fence = fence
+	return l, nil
+}
+
+// 续期，返回锁是否仍由自己持有
+func (l *Lock) Refresh(ttl time.Duration) (bool, error) {
+	n, err := refreshScript.Run(redisPkg.Ctx, redisPkg.RDB, []string{l.Key}, l.Token, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (l *Lock) Release() error {
+	return releaseScript.Run(redisPkg.Ctx, redisPkg.RDB, []string{l.Key}, l.Token).Err()
+}
+
+// 令牌是否仍是最新的：锁没有被其他实例重新获取过
+func (l *Lock) Valid() (bool, error) {
+	return FenceValid(l.Name, l.Fence)
+}
+
+func FenceValid(name string, fence int64) (bool, error) {
+	cur, err := redisPkg.RDB.Get(redisPkg.Ctx, fenceKey(name)).Int64()
+	if err != nil {
+		return false, err
+	}
+	return cur == fence, nil
+}
+
+// 当前持有者实例，未被持有时返回空
+func Holder(name string) (string, error) {
+	token, err := redisPkg.RDB.Get(redisPkg.Ctx, "lock:"+name).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if i := strings.LastIndex(token, "/"); i >= 0 {
+		return token[:i], nil
+	}
+	return token, nil
+}
+
+func fenceKey(name string) string {
+	return "lock:fence:" + name
+}
+
+// 令牌带上实例标识，方便排查当前持有者
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return utils.InstanceID() + "/" + hex.EncodeToString(b)
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 进程内计数器，通过 /stats 暴露
+var counters sync.Map // name -> *atomic.Int64
+
+func counter(name string) *atomic.Int64 {
+	if c, ok := counters.Load(name); ok {
+		return c.(*atomic.Int64)
+	}
+	c, _ := counters.LoadOrStore(name, new(atomic.Int64))
+	return c.(*atomic.Int64)
+}
+
+func Inc(name string) {
+	counter(name).Add(1)
+}
+
+func Add(name string, delta int64) {
+	counter(name).Add(delta)
+}
+
+// 设置瞬时值（gauge）
+func Set(name string, value int64) {
+	counter(name).Store(value)
+}
+
+func Snapshot() map[string]int64 {
+	snap := make(map[string]int64)
+	counters.Range(func(k, v any) bool {
+		snap[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return snap
+}
@@ -0,0 +1,142 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// 默认币种，未指定币种的价格按此解析
+const DefaultCurrency = "CNY"
+
+var (
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// 各币种最小单位的小数位数
+var exponents = map[string]int{
+	"CNY": 2,
+	"HKD": 2,
+	"USD": 2,
+	"EUR": 2,
+	"JPY": 0,
+}
+
+// 金额：以最小货币单位（如分）的整数存储，避免浮点误差
+type Money struct {
+	Amount   int64  `gorm:"not null;default:0"`
+	Currency string `gorm:"size:3;not null;default:'CNY'"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func Exponent(currency string) (int, error) {
+	exp, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	return exp, nil
+}
+
+// 解析十进制金额字符串，如 "12.34"；小数位超过币种精度或为负数时报错
+func Parse(s, currency string) (Money, error) {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	exp, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	intPart, fracPart, hasDot := strings.Cut(strings.TrimSpace(s), ".")
+	if intPart == "" || !digits(intPart) || (hasDot && !digits(fracPart)) || len(fracPart) > exp {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	fracPart += strings.Repeat("0", exp-len(fracPart))
+
+	var amount int64
+	for _, ch := range intPart + fracPart {
+		d := int64(ch - '0')
+		if amount > (math.MaxInt64-d)/10 {
+			return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+		amount = amount*10 + d
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 十进制字符串，如 "12.34"
+func (m Money) String() string {
+	exp, err := Exponent(m.Currency)
+	if err != nil || exp == 0 {
+		return fmt.Sprintf("%d", m.Amount)
+	}
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	unit := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exp, amount%unit)
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// 序列化为 {"amount":"12.34","currency":"CNY"}，金额用字符串保证下游精确
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.String(), Currency: m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	amount := strings.TrimPrefix(v.Amount, "-")
+	parsed, err := Parse(amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	if amount != v.Amount {
+		parsed.Amount = -parsed.Amount
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,138 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"12.34", "CNY", 1234, nil},
+		{"12", "CNY", 1200, nil},
+		{"12.3", "USD", 1230, nil},
+		{"0.01", "EUR", 1, nil},
+		{" 7.5 ", "CNY", 750, nil},
+		{"12.34", "", 1234, nil}, // 默认 CNY
+		{"100", "JPY", 100, nil},
+		{"92233720368547758.07", "CNY", 9223372036854775807, nil},
+		{"9223372036854775807", "JPY", 9223372036854775807, nil},
+
+		{"", "CNY", 0, ErrInvalidAmount},
+		{".", "CNY", 0, ErrInvalidAmount},
+		{".5", "CNY", 0, ErrInvalidAmount},
+		{"12.", "CNY", 0, ErrInvalidAmount},
+		{"1.234", "CNY", 0, ErrInvalidAmount}, // 小数位过多
+		{"-1", "CNY", 0, ErrInvalidAmount},    // 不接受负数
+		{"1e3", "CNY", 0, ErrInvalidAmount},
+		{"1,000", "CNY", 0, ErrInvalidAmount},
+		{"100.5", "JPY", 0, ErrInvalidAmount}, // JPY 没有小数位
+		{"100.", "JPY", 0, ErrInvalidAmount},
+		{"92233720368547758.08", "CNY", 0, ErrInvalidAmount}, // 溢出
+		{"9223372036854775808", "JPY", 0, ErrInvalidAmount},
+		{"1", "XYZ", 0, ErrUnknownCurrency},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, tt.currency)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Parse(%q, %q) error = %v, want %v", tt.in, tt.currency, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q, %q) unexpected error: %v", tt.in, tt.currency, err)
+			continue
+		}
+		want := tt.currency
+		if want == "" {
+			want = DefaultCurrency
+		}
+		if got.Amount != tt.want || got.Currency != want {
+			t.Errorf("Parse(%q, %q) = %+v, want {%d %s}", tt.in, tt.currency, got, tt.want, want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(1234, "CNY"), "12.34"},
+		{New(5, "CNY"), "0.05"},
+		{New(0, "USD"), "0.00"},
+		{New(-5, "CNY"), "-0.05"},
+		{New(-1234, "USD"), "-12.34"},
+		{New(100, "JPY"), "100"},
+		{New(-100, "JPY"), "-100"},
+		{New(12, "XYZ"), "12"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(1234, "CNY"), `{"amount":"12.34","currency":"CNY"}`},
+		{New(-5, "CNY"), `{"amount":"-0.05","currency":"CNY"}`},
+		{New(0, "EUR"), `{"amount":"0.00","currency":"EUR"}`},
+		{New(1500, "JPY"), `{"amount":"1500","currency":"JPY"}`},
+		{New(-1500, "JPY"), `{"amount":"-1500","currency":"JPY"}`},
+		{New(9223372036854775807, "USD"), `{"amount":"92233720368547758.07","currency":"USD"}`},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.m)
+		if err != nil {
+			t.Fatalf("Marshal(%+v): %v", tt.m, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("Marshal(%+v) = %s, want %s", tt.m, data, tt.want)
+		}
+		var back Money
+		if err := json.Unmarshal(data, &back); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		if back != tt.m {
+			t.Errorf("round trip %+v -> %s -> %+v", tt.m, data, back)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	inputs := []string{
+		`{"amount":"1.234","currency":"CNY"}`,
+		`{"amount":"1.5","currency":"JPY"}`,
+		`{"amount":"1","currency":"XYZ"}`,
+		`{"amount":"--1","currency":"CNY"}`,
+		`{"amount":"99999999999999999999","currency":"JPY"}`,
+		`{"amount":12.34,"currency":"CNY"}`,
+	}
+	for _, in := range inputs {
+		var m Money
+		if err := json.Unmarshal([]byte(in), &m); err == nil {
+			t.Errorf("Unmarshal(%s) = %+v, want error", in, m)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	sum, err := New(150, "CNY").Add(New(-50, "CNY"))
+	if err != nil || sum != New(100, "CNY") {
+		t.Errorf("Add = %+v, %v", sum, err)
+	}
+	if _, err := New(1, "CNY").Add(New(1, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add across currencies error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+}
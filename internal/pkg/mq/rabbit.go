@@ -1,7 +1,9 @@
 package mq
 
 import (
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
@@ -12,6 +14,9 @@ var (
 	Conn      *amqp.Connection // RabbitMQ 连接实例
 	Channel   *amqp.Channel    // RabbitMQ 通道实例
 	QueueName = "seckill_queue"
+
+	inspectMu      sync.Mutex
+	inspectChannel *amqp.Channel // 队列巡检专用通道，避免巡检出错关闭发布通道
 )
 
 // 初始化RabbitMQ连接
@@ -57,7 +62,41 @@ func InitRabbitMQ() {
 	log.Println("   - Durable: true")
 }
 
+// 查询队列深度与消费者数量
+func InspectQueue() (amqp.Queue, error) {
+	inspectMu.Lock()
+	defer inspectMu.Unlock()
+
+	if Conn == nil || Conn.IsClosed() {
+		return amqp.Queue{}, fmt.Errorf("rabbitmq connection closed")
+	}
+
+	// 通道出错后会被服务端关闭，下次巡检时重新打开
+	if inspectChannel == nil {
+		ch, err := Conn.Channel()
+		if err != nil {
+			return amqp.Queue{}, err
+		}
+		inspectChannel = ch
+	}
+
+	q, err := inspectChannel.QueueInspect(QueueName)
+	if err != nil {
+		inspectChannel.Close()
+		inspectChannel = nil
+		return amqp.Queue{}, err
+	}
+	return q, nil
+}
+
 func Close() {
+	inspectMu.Lock()
+	if inspectChannel != nil {
+		inspectChannel.Close()
+		inspectChannel = nil
+	}
+	inspectMu.Unlock()
+
 	if Channel != nil {
 		Channel.Close()
 	}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	specs := []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+	}
+	for _, spec := range specs {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) expected error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		spec string
+		from string
+		want string
+	}{
+		{"every minute", "* * * * *", "2024-06-12 10:07", "2024-06-12 10:08"},
+		{"minute step", "*/15 * * * *", "2024-06-12 10:07", "2024-06-12 10:15"},
+		{"exact time is excluded", "30 9 * * *", "2024-06-12 09:30", "2024-06-13 09:30"},
+		{"list", "0 8,20 * * *", "2024-06-12 09:00", "2024-06-12 20:00"},
+		{"range with step", "0 1-10/3 * * *", "2024-06-12 04:30", "2024-06-12 07:00"},
+		{"end of month rolls over", "0 0 * * *", "2024-01-31 10:00", "2024-02-01 00:00"},
+		{"day 31 skips short months", "0 0 31 * *", "2024-04-01 00:00", "2024-05-31 00:00"},
+		{"leap day", "0 0 29 2 *", "2023-03-01 00:00", "2024-02-29 00:00"},
+		{"year rollover", "0 0 1 1 *", "2024-12-31 23:59", "2025-01-01 00:00"},
+		{"weekdays", "30 9 * * 1-5", "2024-06-14 10:00", "2024-06-17 09:30"},
+		{"sunday as 0", "0 12 * * 0", "2024-06-12 00:00", "2024-06-16 12:00"},
+		{"sunday as 7", "0 12 * * 7", "2024-06-12 00:00", "2024-06-16 12:00"},
+		{"sunday as 7 in range", "0 12 * * 5-7", "2024-06-15 13:00", "2024-06-16 12:00"},
+		// 日和周都限定时满足其一：9 月 1 日是周日，先于下一个周一
+		{"dom or dow", "0 0 1 * 1", "2024-08-27 00:00", "2024-09-01 00:00"},
+		{"dom or dow picks dow", "0 0 1 * 1", "2024-09-01 00:00", "2024-09-02 00:00"},
+		// 以 * 开头的 */n 与 * 一样按"且"匹配：奇数日且周一，跳过 8 月 29 日（周四）与 9 月 2 日（偶数日）
+		{"dom step uses and", "0 0 */2 * 1", "2024-08-27 10:00", "2024-09-09 00:00"},
+		{"dow step is unrestricted", "0 0 15 * */1", "2024-08-27 10:00", "2024-09-15 00:00"},
+		{"dom only", "0 0 15 * *", "2024-08-27 10:00", "2024-09-15 00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.spec, err)
+			}
+			got := c.Next(at(tt.from))
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.Format("2006-01-02 15:04 Mon"), want.Format("2006-01-02 15:04 Mon"))
+			}
+		})
+	}
+}
+
+func TestCronNextNoMatch(t *testing.T) {
+	c, err := parseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %s, want zero time", got)
+	}
+}
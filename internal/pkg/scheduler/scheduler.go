@@ -0,0 +1,198 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"seckill-system/internal/pkg/lock"
+)
+
+const defaultJobTimeout = time.Minute
+
+// 定时任务：Every 与 Cron 二选一
+type Job struct {
+	Name       string
+	Every      time.Duration // 固定间隔
+	Cron       string        // 5 段 cron 表达式
+	Timeout    time.Duration // 单次执行超时，默认 1 分钟
+	LeaderOnly bool          // 只在 leader 实例上执行
+	Run        func(ctx context.Context) error
+}
+
+type JobStatus struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	LeaderOnly   bool      `json:"leader_only"`
+	Running      bool      `json:"running"`
+	LastRun      time.Time `json:"last_run"`
+	LastDuration string    `json:"last_duration"`
+	LastResult   string    `json:"last_result"` // ok, error, timeout, skipped
+	LastError    string    `json:"last_error,omitempty"`
+	NextRun      time.Time `json:"next_run"`
+	Runs         int64     `json:"runs"`
+	Failures     int64     `json:"failures"`
+}
+
+type entry struct {
+	job  Job
+	next func(time.Time) time.Time
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+type Scheduler struct {
+	Elector *lock.Elector
+
+	entries []*entry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func New(elector *lock.Elector) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{Elector: elector, ctx: ctx, cancel: cancel}
+}
+
+// 注册任务，需在 Start 之前调用
+func (s *Scheduler) Add(job Job) error {
+	e := &entry{job: job}
+	switch {
+	case job.Cron != "":
+		c, err := parseCron(job.Cron)
+		if err != nil {
+			return err
+		}
+		e.next = c.Next
+		e.status.Schedule = "cron " + job.Cron
+	case job.Every > 0:
+		every := job.Every
+		e.next = func(t time.Time) time.Time { return t.Add(every) }
+		e.status.Schedule = "every " + every.String()
+	default:
+		return fmt.Errorf("job %s: either Every or Cron is required", job.Name)
+	}
+	if e.job.Timeout <= 0 {
+		e.job.Timeout = defaultJobTimeout
+	}
+	e.status.Name = job.Name
+	e.status.LeaderOnly = job.LeaderOnly
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *Scheduler) Start() {
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+	log.Printf("Scheduler started with %d jobs", len(s.entries))
+}
+
+// 停止调度并等待正在执行的任务结束（最多等到 ctx 截止）
+func (s *Scheduler) Stop(ctx context.Context) {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("Scheduler stopped")
+	case <-ctx.Done():
+		log.Println("Scheduler stop timed out, some jobs are still running")
+	}
+}
+
+func (s *Scheduler) Status() []JobStatus {
+	list := make([]JobStatus, 0, len(s.entries))
+	for _, e := range s.entries {
+		e.mu.Lock()
+		list = append(list, e.status)
+		e.mu.Unlock()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+	for {
+		next := e.next(time.Now())
+		if next.IsZero() {
+			log.Printf("⚠️ [定时任务无下次触发时间]: %s", e.job.Name)
+			return
+		}
+		e.mu.Lock()
+		e.status.NextRun = next
+		e.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.runOnce(e)
+	}
+}
+
+func (s *Scheduler) runOnce(e *entry) {
+	if e.job.LeaderOnly && s.Elector != nil && !s.Elector.IsLeader() {
+		e.mu.Lock()
+		e.status.LastResult = "skipped"
+		e.status.LastError = "not leader"
+		e.mu.Unlock()
+		return
+	}
+
+	start := time.Now()
+	e.mu.Lock()
+	e.status.Running = true
+	e.status.LastRun = start
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(s.ctx, e.job.Timeout)
+	err := safeRun(ctx, e.job.Run)
+	timedOut := ctx.Err() == context.DeadlineExceeded
+	cancel()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status.Running = false
+	e.status.LastDuration = time.Since(start).String()
+	e.status.Runs++
+	switch {
+	case err == nil:
+		e.status.LastResult = "ok"
+		e.status.LastError = ""
+	case timedOut:
+		e.status.LastResult = "timeout"
+		e.status.LastError = err.Error()
+		e.status.Failures++
+	default:
+		e.status.LastResult = "error"
+		e.status.LastError = err.Error()
+		e.status.Failures++
+	}
+	if err != nil {
+		log.Printf("⚠️ [定时任务失败]: %s, err=%v", e.job.Name, err)
+	}
+}
+
+// 任务 panic 不影响调度器
+func safeRun(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
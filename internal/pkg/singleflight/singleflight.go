@@ -0,0 +1,67 @@
+package singleflight
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// 合并同一个key的并发调用：只有第一个调用真正执行，其余等待并共享结果
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// fn 发生 panic 时等待的调用收到该错误，执行 fn 的调用重新 panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: fn panicked: %v\n\n%s", p.Value, p.Stack)
+}
+
+// shared=true 表示结果来自其他调用
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.run(fn)
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	c.wg.Done()
+
+	if pe, ok := c.err.(*PanicError); ok {
+		panic(pe)
+	}
+	return c.val, c.err, false
+}
+
+// 执行 fn，panic 转为 PanicError 交给等待者
+func (c *call) run(fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	c.val, c.err = fn()
+}
@@ -0,0 +1,92 @@
+package singleflight
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("k", func() (interface{}, error) { return 42, nil })
+	if v != 42 || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v; want 42, nil, false", v, err, shared)
+	}
+	want := errors.New("boom")
+	if _, err, _ := g.Do("k", func() (interface{}, error) { return nil, want }); err != want {
+		t.Fatalf("Do error = %v, want %v", err, want)
+	}
+}
+
+// 并发调用只执行一次 fn，结果共享给等待者
+func TestDoCoalesces(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, _ := g.Do("k", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "v", nil
+			})
+			if v != "v" || err != nil {
+				t.Errorf("Do = %v, %v; want v, nil", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+}
+
+// fn panic 时执行者重新 panic，等待者拿到 PanicError 而不是 nil, nil
+func TestDoPanic(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	leaderPanic := make(chan interface{}, 1)
+	go func() {
+		defer func() { leaderPanic <- recover() }()
+		g.Do("k", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("bad")
+		})
+	}()
+	<-started
+
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, err, shared := g.Do("k", func() (interface{}, error) { return "unexpected", nil })
+		if !shared {
+			err = errors.New("waiter ran fn itself")
+		}
+		waiterErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	var pe *PanicError
+	if r := <-leaderPanic; r == nil {
+		t.Fatal("leader did not panic")
+	} else if p, ok := r.(*PanicError); !ok || p.Value != "bad" {
+		t.Fatalf("leader panic = %v, want *PanicError(bad)", r)
+	}
+	if err := <-waiterErr; !errors.As(err, &pe) || pe.Value != "bad" {
+		t.Fatalf("waiter error = %v, want *PanicError(bad)", err)
+	}
+
+	// panic 之后 key 已释放，可以重新执行
+	if v, err, _ := g.Do("k", func() (interface{}, error) { return 1, nil }); v != 1 || err != nil {
+		t.Fatalf("Do after panic = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,574 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/money"
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 组合商品：本身是一个不持有库存的商品，组成登记在 bundle:items:{id}（hash: 组件商品ID -> 每套件数），
+// 秒杀一套时在一个 Lua 脚本里扣减全部组件，任一组件不足则全部不动
+const maxBundleItems = 20
+
+var (
+	ErrInvalidBundle = errors.New("invalid bundle")
+	ErrNotBundle     = errors.New("product is not a bundle")
+)
+
+func bundleItemsKey(productID uint) string {
+	return fmt.Sprintf("bundle:items:%d", productID)
+}
+
+type bundleComponent struct {
+	ProductID uint
+	Quantity  int64 // 每套件数
+}
+
+// 组合商品的组成缓存，创建后不再变化。
+// 查询发生在 checkProduct 之后，而组成先于商品ID登记，所以"不是组合商品"的结果也可以缓存
+type bundleLayout struct {
+	mu    sync.RWMutex
+	items map[uint][]bundleComponent
+}
+
+var bundles = &bundleLayout{items: make(map[uint][]bundleComponent)}
+
+// 返回组合商品的组件（按商品ID排序），普通商品返回 nil
+func (l *bundleLayout) Components(productID uint) ([]bundleComponent, error) {
+	l.mu.RLock()
+	items, ok := l.items[productID]
+	l.mu.RUnlock()
+	if ok {
+		return items, nil
+	}
+
+	fields, err := redisPkg.RDB.HGetAll(redisPkg.Ctx, bundleItemsKey(productID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for field, v := range fields {
+		id, _ := strconv.ParseUint(field, 10, 64)
+		items = append(items, bundleComponent{ProductID: uint(id), Quantity: parseInt64(v)})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ProductID < items[j].ProductID })
+	l.Set(productID, items)
+	return items, nil
+}
+
+// 从 MySQL 读取组合商品的组件，Redis 不可用时兜底
+func bundleComponentsFromDB(db *gorm.DB, bundleID uint) ([]bundleComponent, error) {
+	var rows []model.BundleItem
+	if err := db.Where("bundle_id = ?", bundleID).Order("product_id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]bundleComponent, len(rows))
+	for i, row := range rows {
+		items[i] = bundleComponent{ProductID: row.ProductID, Quantity: int64(row.Quantity)}
+	}
+	return items, nil
+}
+
+func (l *bundleLayout) Set(productID uint, items []bundleComponent) {
+	l.mu.Lock()
+	l.items[productID] = items
+	l.mu.Unlock()
+}
+
+func (l *bundleLayout) Remove(productID uint) {
+	l.mu.Lock()
+	delete(l.items, productID)
+	l.mu.Unlock()
+}
+
+func registerBundle(pipe redis.Cmdable, productID uint, items []bundleComponent) {
+	fields := make(map[string]interface{}, len(items))
+	for _, it := range items {
+		fields[strconv.FormatUint(uint64(it.ProductID), 10)] = it.Quantity
+	}
+	pipe.HSet(redisPkg.Ctx, bundleItemsKey(productID), fields)
+}
+
+// 全部组件一起扣减：KEYS 为各组件的库存分片key依次拼接，
+// ARGV[1] 为组件数，之后每个组件两个参数：分片key数、需要件数。
+// 先检查再扣减，返回 -1 库存未同步，0 某个组件不足（全部不动），1 成功
+var bundleStockScript = redis.NewScript(`
+local c = tonumber(ARGV[1])
+local k = 1
+for i = 1, c do
+	local cnt = tonumber(ARGV[i * 2])
+	local need = tonumber(ARGV[i * 2 + 1])
+	local total = 0
+	for j = k, k + cnt - 1 do
+		local v = redis.call('GET', KEYS[j])
+		if not v then return -1 end
+		total = total + tonumber(v)
+	end
+	if total < need then return 0 end
+	k = k + cnt
+end
+k = 1
+for i = 1, c do
+	local cnt = tonumber(ARGV[i * 2])
+	local need = tonumber(ARGV[i * 2 + 1])
+	for j = k, k + cnt - 1 do
+		if need == 0 then break end
+		local n = math.min(tonumber(redis.call('GET', KEYS[j])), need)
+		if n > 0 then
+			redis.call('DECRBY', KEYS[j], n)
+			need = need - n
+		end
+	end
+	k = k + cnt
+end
+return 1
+`)
+
+// 扣减 n 套的全部组件库存，要么全部成功，要么都不动
+func deductBundleStock(items []bundleComponent, n int64) error {
+	var keys []string
+	args := []interface{}{len(items)}
+	for _, it := range items {
+		shards, err := layouts.Shards(it.ProductID)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, stockKeys(it.ProductID, shards)...)
+		args = append(args, shards, it.Quantity*n)
+	}
+	res, err := bundleStockScript.Run(redisPkg.Ctx, redisPkg.RDB, keys, args...).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case -1:
+		return ErrStockNotSynced
+	case 0:
+		return ErrOutOfStock
+	}
+	return nil
+}
+
+// 归还 n 套的全部组件库存
+func restoreBundleStock(items []bundleComponent, n int64) {
+	for _, it := range items {
+		if err := restoreStock(it.ProductID, 0, it.Quantity*n); err != nil {
+			log.Printf("⚠️ [组件库存回滚失败]: productID=%d, err=%v", it.ProductID, err)
+		}
+	}
+}
+
+// 增减 n 套对应的组件在途件数，n 为负时减少
+func bundleInflight(items []bundleComponent, n int64) {
+	pipe := redisPkg.RDB.Pipeline()
+	for _, it := range items {
+		pipe.IncrBy(redisPkg.Ctx, inflightKey(it.ProductID), it.Quantity*n)
+	}
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+		log.Printf("⚠️ [组件在途计数失败]: err=%v", err)
+	}
+}
+
+// 消费者落库：按商品ID顺序逐个扣减组件库存（固定加锁顺序，避免死锁），任一不足整个事务回滚；
+// 返回扣减后的组件快照，顺序与 items 一致
+func deductBundleComponents(tx *gorm.DB, items []bundleComponent, sets int) ([]model.Product, error) {
+	parts := make([]model.Product, len(items))
+	for i, it := range items {
+		need := int(it.Quantity) * sets
+		result := tx.Model(&model.Product{}).
+			Where("id = ? AND stock >= ?", it.ProductID, need).
+			Update("stock", gorm.Expr("stock - ?", need))
+		if result.Error != nil {
+			return nil, fmt.Errorf("更新组件库存失败: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil, fmt.Errorf("组件库存不足: productID=%d", it.ProductID)
+		}
+		err := tx.Select("id", "name", "stock", "price_amount", "price_currency").
+			First(&parts[i], it.ProductID).Error
+		if err != nil {
+			return nil, fmt.Errorf("查询组件失败: %v", err)
+		}
+	}
+	return parts, nil
+}
+
+// 写入组合商品订单的明细与各组件的售出流水
+func writeBundleLines(tx *gorm.DB, order *model.Order, items []bundleComponent, parts []model.Product, actor string) error {
+	lines := make([]model.OrderItem, len(items))
+	for i, it := range items {
+		need := int(it.Quantity) * order.Quantity
+		lines[i] = model.OrderItem{
+			OrderID:     order.ID,
+			ProductID:   it.ProductID,
+			ProductName: parts[i].Name,
+			Quantity:    need,
+			UnitPrice:   parts[i].Price,
+		}
+		err := tx.Create(&model.InventoryLedger{
+			ProductID: it.ProductID,
+			Type:      model.LedgerSale,
+			Quantity:  -need,
+			Before:    parts[i].Stock + need,
+			After:     parts[i].Stock,
+			OrderID:   &order.ID,
+			Actor:     actor,
+			Reason:    fmt.Sprintf("bundle %d", order.ProductID),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("库存流水写入失败: %v", err)
+		}
+	}
+	if err := tx.Create(&lines).Error; err != nil {
+		return fmt.Errorf("订单明细写入失败: %v", err)
+	}
+	return nil
+}
+
+// 取消组合商品订单：按商品ID顺序锁定组件并退回 MySQL 库存、记流水；
+// 返回需要退回 Redis 的组件（已归档的组件不再退回），Quantity 为退回的总件数
+func restoreBundleComponents(tx *gorm.DB, order *model.Order, actor, reason string) ([]bundleComponent, error) {
+	var lines []model.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Order("product_id").Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	var live []bundleComponent
+	for _, line := range lines {
+		var part model.Product
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "stock", "deleted_at").First(&part, line.ProductID).Error
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Unscoped().Model(&model.Product{}).Where("id = ?", line.ProductID).
+			Update("stock", gorm.Expr("stock + ?", line.Quantity)).Error
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Create(&model.InventoryLedger{
+			ProductID: line.ProductID,
+			Type:      model.LedgerCancel,
+			Quantity:  line.Quantity,
+			Before:    part.Stock,
+			After:     part.Stock + line.Quantity,
+			OrderID:   &order.ID,
+			Actor:     actor,
+			Reason:    reason,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+		if !part.DeletedAt.Valid {
+			live = append(live, bundleComponent{ProductID: line.ProductID, Quantity: int64(line.Quantity)})
+		}
+	}
+	return live, nil
+}
+
+type BundleService struct {
+	DB    *gorm.DB
+	Cache *ProductCache
+}
+
+type BundleComponent struct {
+	ProductID uint `json:"product_id"`
+	Quantity  int  `json:"quantity"`
+}
+
+type BundleParams struct {
+	Name          string
+	Price         money.Money
+	SeckillPrice  money.Money
+	PurchaseLimit int
+	Items         []BundleComponent
+}
+
+type BundleItemView struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	Stock     int64  `json:"stock"` // 组件实时可售库存
+}
+
+// 对外展示的组合商品，Stock 为按组件库存算出的可售套数
+type BundleView struct {
+	ID           uint             `json:"id"`
+	Name         string           `json:"name"`
+	Price        money.Money      `json:"price"`
+	SeckillPrice money.Money      `json:"seckill_price"`
+	Status       string           `json:"status"`
+	Stock        int64            `json:"stock"`
+	Items        []BundleItemView `json:"items"`
+}
+
+func (p *BundleParams) validate() error {
+	if len(p.Items) == 0 || len(p.Items) > maxBundleItems {
+		return fmt.Errorf("%w: need 1 to %d items", ErrInvalidBundle, maxBundleItems)
+	}
+	seen := make(map[uint]bool, len(p.Items))
+	units := 0
+	for _, it := range p.Items {
+		if it.ProductID == 0 || it.Quantity < 1 {
+			return fmt.Errorf("%w: product_id and a positive quantity are required", ErrInvalidBundle)
+		}
+		if seen[it.ProductID] {
+			return fmt.Errorf("%w: duplicate product %d", ErrInvalidBundle, it.ProductID)
+		}
+		seen[it.ProductID] = true
+		units += it.Quantity
+	}
+	if units < 2 {
+		return fmt.Errorf("%w: a bundle must contain at least 2 units", ErrInvalidBundle)
+	}
+	return checkSeckillPrice(p.Price, p.SeckillPrice)
+}
+
+// 新建组合商品：商品行（库存恒为 0）与组成在同一事务写入，
+// Redis 中先登记组成、最后登记商品ID，登记完成前秒杀请求会被 checkProduct 拒绝
+func (s *BundleService) Create(params BundleParams) (*BundleView, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(params.Items))
+	for i, it := range params.Items {
+		ids[i] = it.ProductID
+	}
+
+	product := model.Product{
+		Name:          params.Name,
+		Price:         params.Price,
+		SeckillPrice:  params.SeckillPrice,
+		StockShards:   1,
+		PurchaseLimit: params.PurchaseLimit,
+	}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var parts []model.Product
+		if err := tx.Select("id", "status").Where("id IN ?", ids).Find(&parts).Error; err != nil {
+			return err
+		}
+		if len(parts) != len(ids) {
+			return ErrProductNotFound
+		}
+		// 组件必须在架，且不能是抽签商品：抽签商品的库存只能开奖发放
+		for _, p := range parts {
+			if p.Status != model.ProductOnShelf {
+				return fmt.Errorf("%w: component %d is off shelf", ErrInvalidBundle, p.ID)
+			}
+		}
+		var count int64
+		err := tx.Model(&model.Lottery{}).Where("product_id IN ? AND status <> ?", ids, model.LotteryDrawn).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: a component must not be sold by lottery", ErrInvalidBundle)
+		}
+		// 组件不能是组合商品或有规格的商品
+		if err := tx.Model(&model.BundleItem{}).Where("bundle_id IN ?", ids).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: a component must not be a bundle", ErrInvalidBundle)
+		}
+		if err := tx.Model(&model.SKU{}).Where("product_id IN ?", ids).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: a component must not have skus", ErrInvalidBundle)
+		}
+
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		rows := make([]model.BundleItem, len(params.Items))
+		for i, it := range params.Items {
+			rows[i] = model.BundleItem{BundleID: product.ID, ProductID: it.ProductID, Quantity: it.Quantity}
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]bundleComponent, len(params.Items))
+	for i, it := range params.Items {
+		items[i] = bundleComponent{ProductID: it.ProductID, Quantity: int64(it.Quantity)}
+	}
+	// 登记失败时商品ID尚未登记，秒杀请求会被拒绝，重启回灌会补齐组成
+	pipe := redisPkg.RDB.TxPipeline()
+	registerBundle(pipe, product.ID, items)
+	setPurchaseLimit(pipe, product.ID, product.PurchaseLimit)
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+		return nil, err
+	}
+	if _, err := initStock(product.ID, 0, 1, false); err != nil {
+		return nil, err
+	}
+	if err := registerProducts(product.ID); err != nil {
+		return nil, err
+	}
+	log.Printf("🎁 [组合商品已创建]: productID=%d, items=%d", product.ID, len(items))
+
+	s.Cache.Invalidate()
+	return s.Get(product.ID)
+}
+
+// 组合商品详情：各组件的实时库存及可售套数
+func (s *BundleService) Get(id uint) (*BundleView, error) {
+	var product model.Product
+	err := s.DB.First(&product, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rows []model.BundleItem
+	if err := s.DB.Where("bundle_id = ?", id).Order("product_id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotBundle
+	}
+
+	ids := make([]uint, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ProductID
+	}
+	var components []model.Product
+	err = s.DB.Unscoped().Select("id", "name", "stock", "stock_shards").Where("id IN ?", ids).Find(&components).Error
+	if err != nil {
+		return nil, err
+	}
+	live, err := liveStock(components)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]model.Product, len(components))
+	for _, c := range components {
+		byID[c.ID] = c
+	}
+
+	view := &BundleView{
+		ID:           product.ID,
+		Name:         product.Name,
+		Price:        product.Price,
+		SeckillPrice: product.SeckillPrice,
+		Status:       product.Status,
+		Items:        make([]BundleItemView, 0, len(rows)),
+	}
+	sets := int64(-1)
+	for _, r := range rows {
+		c := byID[r.ProductID]
+		stock, ok := live[c.ID]
+		if !ok {
+			stock = int64(c.Stock)
+		}
+		view.Items = append(view.Items, BundleItemView{
+			ProductID: r.ProductID,
+			Name:      c.Name,
+			Quantity:  r.Quantity,
+			Stock:     stock,
+		})
+		if n := stock / int64(r.Quantity); sets < 0 || n < sets {
+			sets = n
+		}
+	}
+	view.Stock = max(sets, 0)
+	return view, nil
+}
+
+// 组合商品的实时库存 = 各组件可凑出的最少套数
+func (s *ProductService) overlayBundleStock(products []model.Product, live map[uint]int64) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	var rows []model.BundleItem
+	if err := s.DB.Where("bundle_id IN ?", ids).Find(&rows).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	componentIDs := make([]uint, 0, len(rows))
+	for _, r := range rows {
+		componentIDs = append(componentIDs, r.ProductID)
+	}
+	var components []model.Product
+	err := s.DB.Unscoped().Select("id", "stock", "stock_shards").Where("id IN ?", componentIDs).Find(&components).Error
+	if err != nil {
+		return err
+	}
+	componentLive, err := liveStock(components)
+	if err != nil {
+		return err
+	}
+	for _, c := range components {
+		if _, ok := componentLive[c.ID]; !ok {
+			componentLive[c.ID] = int64(c.Stock)
+		}
+	}
+
+	sets := make(map[uint]int64)
+	for _, r := range rows {
+		n := componentLive[r.ProductID] / int64(r.Quantity)
+		if cur, ok := sets[r.BundleID]; !ok || n < cur {
+			sets[r.BundleID] = n
+		}
+	}
+	for id, n := range sets {
+		live[id] = max(n, 0)
+	}
+	return nil
+}
+
+// 回灌组合商品的组成
+func (s *ProductService) syncBundles(report *SyncReport) error {
+	var lastID uint
+	for {
+		var ids []uint
+		err := s.DB.Model(&model.BundleItem{}).Distinct("bundle_id").
+			Where("bundle_id > ?", lastID).Order("bundle_id").Limit(syncBatchSize).
+			Pluck("bundle_id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		lastID = ids[len(ids)-1]
+		report.Bundles += len(ids)
+
+		var rows []model.BundleItem
+		if err := s.DB.Where("bundle_id IN ?", ids).Find(&rows).Error; err != nil {
+			return err
+		}
+		grouped := make(map[uint][]bundleComponent, len(ids))
+		for _, r := range rows {
+			grouped[r.BundleID] = append(grouped[r.BundleID], bundleComponent{ProductID: r.ProductID, Quantity: int64(r.Quantity)})
+		}
+		pipe := redisPkg.RDB.Pipeline()
+		for id, items := range grouped {
+			registerBundle(pipe, id, items)
+		}
+		if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+			return err
+		}
+	}
+}
@@ -2,14 +2,30 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"seckill-system/internal/model"
 	mqPkg "seckill-system/internal/pkg/mq"
+	redisPkg "seckill-system/internal/pkg/redis"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// 还没开始处理就失败（如数据库暂时不可用）的消息重新入队，稍后重试
+var errRetryLater = errors.New("retry later")
+
+const (
+	consumerRetryDelay = time.Second
+	inflightAckedTTL   = 24 * time.Hour
+)
+
+// 记录已扣回在途的请求ID，重复投递的消息不再扣减
+func inflightAckedKey(requestID string) string {
+	return "stock:inflight:acked:" + requestID
+}
+
 type OrderConsumer struct {
 	DB *gorm.DB
 }
@@ -43,7 +59,16 @@ func (oc *OrderConsumer) Start() {
 	// 启动一个 goroutine 来处理消息
 	go func() {
 		for msg := range msgs {
-			oc.handleMessage(msg.Body)
+			err := oc.handleMessage(msg.Body)
+			if errors.Is(err, errRetryLater) {
+				log.Printf("⚠️ [订单处理稍后重试]: %v", err)
+				time.Sleep(consumerRetryDelay)
+				msg.Nack(false, true)
+				continue
+			}
+			if err != nil {
+				log.Printf("⚠️ [订单处理失败]: %v", err)
+			}
 			msg.Ack(false)
 		}
 	}()
@@ -56,44 +81,123 @@ func (oc *OrderConsumer) handleMessage(body []byte) error {
 	if err != nil {
 		return fmt.Errorf("消息解析失败: %v", err)
 	}
-
-	//幂等性检查
-	log.Printf("📦 [处理中]: UserID=%d, ProductID=%d", message.UserID, message.ProductID)
+	quantity := max(message.Quantity, 1)
+	hold := &stockHold{productID: message.ProductID, skuID: message.SKUID}
+	if message.Bundle {
+		// 组件决定在途计数记在哪些商品上，查不到就无法在确认消息时扣回在途，改从 MySQL 读取，
+		// 仍失败则重新入队（消息未确认，在途计数保持不变）
+		if hold.components, err = bundles.Components(message.ProductID); err != nil {
+			if hold.components, err = bundleComponentsFromDB(oc.DB, message.ProductID); err != nil {
+				return fmt.Errorf("%w: 查询组合商品失败: %v", errRetryLater, err)
+			}
+		}
+	}
+	//幂等性检查：按请求ID去重；旧消息没有请求ID，仍按用户+商品去重
+	log.Printf("📦 [处理中]: UserID=%d, ProductID=%d, Quantity=%d", message.UserID, message.ProductID, quantity)
 	var existingorder model.Order
-	err = oc.DB.Where("user_id = ? AND product_id = ?", message.UserID, message.ProductID).First(&existingorder).Error
+	if message.RequestID != "" {
+		err = oc.DB.Where("request_id = ?", message.RequestID).First(&existingorder).Error
+	} else {
+		err = oc.DB.Where("user_id = ? AND product_id = ?", message.UserID, message.ProductID).First(&existingorder).Error
+	}
 
-	//订单已存在，返回
+	//订单已存在，返回；上次处理可能在扣回在途前中断，按请求ID补扣一次
 	if err == nil {
 		log.Printf("⚠️ [已存在订单]: orderID=%d", existingorder.ID)
+		if message.RequestID != "" {
+			ackInflight(message.RequestID, hold, quantity)
+		}
 		return nil
 	}
 
 	//数据库错误
 	if err != gorm.ErrRecordNotFound {
-		// 数据库查询失败
-		return fmt.Errorf("查询订单失败: %v", err)
+		// 数据库查询失败，还未做任何处理，重新入队，在途计数保持不变
+		return fmt.Errorf("%w: 查询订单失败: %v", errRetryLater, err)
 	}
 
+	// 新消息处理完即不再在途
+	defer ackInflight(message.RequestID, hold, quantity)
+
 	//事务保证原子性
 	err = oc.DB.Transaction(func(tx *gorm.DB) error {
-		//1.扣减库存
-		result := tx.Model(&model.Product{}).
-			Where("id = ? AND stock > 0", message.ProductID).
-			Update("stock", gorm.Expr("stock - ?", 1))
+		//1.扣减库存，有规格的扣规格库存，组合商品扣各组件库存
+		var parts []model.Product
+		if message.Bundle {
+			if parts, err = deductBundleComponents(tx, hold.components, quantity); err != nil {
+				return err
+			}
+		} else {
+			var result *gorm.DB
+			if message.SKUID != 0 {
+				result = tx.Model(&model.SKU{}).
+					Where("id = ? AND product_id = ? AND stock >= ?", message.SKUID, message.ProductID, quantity).
+					Update("stock", gorm.Expr("stock - ?", quantity))
+			} else {
+				result = tx.Model(&model.Product{}).
+					Where("id = ? AND stock >= ?", message.ProductID, quantity).
+					Update("stock", gorm.Expr("stock - ?", quantity))
+			}
 
-		if result.Error != nil {
-			return fmt.Errorf("更新库存失败: %v", result.Error)
+			if result.Error != nil {
+				return fmt.Errorf("更新库存失败: %v", result.Error)
+			}
+
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("库存不足")
+			}
+		}
+
+		//2.读取商品快照（行锁仍在，读到的就是本次扣减后的库存和当前价格）
+		var product model.Product
+		err = tx.Select("id", "name", "stock", "price_amount", "price_currency",
+			"seckill_price_amount", "seckill_price_currency", "campaign_group_id").
+			First(&product, message.ProductID).Error
+		if err != nil {
+			return fmt.Errorf("查询商品失败: %v", err)
+		}
+
+		// 规格商品的价格与库存以规格为准
+		var sku *model.SKU
+		stock, unitPrice, price := product.Stock, product.Price, product.ChargePrice()
+		if message.SKUID != 0 {
+			sku = &model.SKU{}
+			err = tx.Select("id", "name", "stock", "price_amount", "price_currency",
+				"seckill_price_amount", "seckill_price_currency").
+				First(sku, message.SKUID).Error
+			if err != nil {
+				return fmt.Errorf("查询规格失败: %v", err)
+			}
+			stock, unitPrice, price = sku.Stock, sku.Price, sku.ChargePrice()
 		}
 
-		if result.RowsAffected == 0 {
-			return fmt.Errorf("库存不足")
+		//3.复核活动组跨商品限购
+		if product.CampaignGroupID != nil {
+			if err := checkGroupLimits(tx, message.UserID, *product.CampaignGroupID, quantity); err != nil {
+				return err
+			}
 		}
 
-		//2.创建订单
+		//4.创建订单，记录下单时的名称与价格
 		order := model.Order{
-			UserID:    message.UserID,
-			ProductID: message.ProductID,
-			Status:    "pending",
+			UserID:       message.UserID,
+			ProductID:    message.ProductID,
+			Bundle:       message.Bundle,
+			Status:       model.OrderPending,
+			ProductName:  product.Name,
+			Quantity:     quantity,
+			UnitPrice:    unitPrice,
+			SeckillPrice: price,
+			Total:        price.Mul(int64(quantity)),
+
+			CampaignGroupID: product.CampaignGroupID,
+		}
+		if message.RequestID != "" {
+			order.RequestID = &message.RequestID
+		}
+		if sku != nil {
+			order.SKUID = &sku.ID
+			order.SKUName = sku.Name
 		}
 
 		err = tx.Create(&order).Error
@@ -101,13 +205,80 @@ func (oc *OrderConsumer) handleMessage(body []byte) error {
 			return fmt.Errorf("订单创建失败: %v", err)
 		}
 
+		//5.记录库存流水，组合商品同时写入订单明细
+		actor := fmt.Sprintf("user:%d", message.UserID)
+		if message.Bundle {
+			if err := writeBundleLines(tx, &order, hold.components, parts, actor); err != nil {
+				return err
+			}
+			log.Printf("✅ [订单创建成功]: orderID=%d, bundle items=%d", order.ID, len(parts))
+			return nil
+		}
+		err = tx.Create(&model.InventoryLedger{
+			ProductID: message.ProductID,
+			SKUID:     order.SKUID,
+			Type:      model.LedgerSale,
+			Quantity:  -quantity,
+			Before:    stock + quantity,
+			After:     stock,
+			OrderID:   &order.ID,
+			Actor:     actor,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("库存流水写入失败: %v", err)
+		}
+
 		log.Printf("✅ [订单创建成功]: orderID=%d", order.ID)
 		return nil
 	})
 
 	if err != nil {
+		// 复核未通过、库存不足或写库失败：订单不落库，退回请求时预占的库存与额度
+		if errors.Is(err, ErrQuotaExceeded) {
+			log.Printf("⚠️ [超出活动组限购]: UserID=%d, ProductID=%d, err=%v", message.UserID, message.ProductID, err)
+		}
+		oc.rollback(&message, hold, quantity)
 		return err
 	}
 
 	return nil
 }
+
+// 扣回在途计数；带请求ID的消息只扣一次，确认丢失导致的重复投递不会重复扣减
+func ackInflight(requestID string, hold *stockHold, quantity int) {
+	if requestID != "" {
+		ok, err := redisPkg.RDB.SetNX(redisPkg.Ctx, inflightAckedKey(requestID), 1, inflightAckedTTL).Result()
+		if err != nil {
+			log.Printf("⚠️ [在途计数扣回失败]: requestID=%s, err=%v", requestID, err)
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+	hold.inflight(-int64(quantity))
+}
+
+// 退回请求时在 Redis 预占的库存与限购额度
+func (oc *OrderConsumer) rollback(message *model.SeckillMessage, hold *stockHold, quantity int) {
+	// 提交结果未知（如提交后连接断开）或重复投递并发落库时订单可能已经存在，不能退回
+	if message.RequestID != "" {
+		var count int64
+		err := oc.DB.Model(&model.Order{}).Where("request_id = ?", message.RequestID).Count(&count).Error
+		if err != nil {
+			log.Printf("⚠️ [无法确认订单是否落库，未退回库存]: requestID=%s, err=%v", message.RequestID, err)
+			return
+		}
+		if count > 0 {
+			return
+		}
+	}
+
+	var groupID *uint
+	oc.DB.Model(&model.Product{}).Select("campaign_group_id").Where("id = ?", message.ProductID).Scan(&groupID)
+	hold.restore(int64(quantity))
+	if err := releaseQuota(message.UserID, message.ProductID, groupID, int64(quantity)); err != nil {
+		log.Printf("⚠️ [限购额度回滚失败]: userID=%d, productID=%d, err=%v", message.UserID, message.ProductID, err)
+	}
+	log.Printf("↩️ [订单未落库，已退回库存与额度]: UserID=%d, ProductID=%d, Quantity=%d", message.UserID, message.ProductID, quantity)
+}
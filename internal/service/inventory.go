@@ -0,0 +1,238 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"seckill-system/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StockModeAdd = "add" // 在现有库存上增加
+	StockModeSet = "set" // 把库存设置为指定值
+)
+
+type StockAdjustment struct {
+	ProductID uint   `json:"product_id"`
+	SKUID     uint   `json:"sku_id,omitempty"`
+	Mode      string `json:"mode"`
+	Delta     int    `json:"delta"`
+	Before    int    `json:"before"`
+	After     int    `json:"after"`
+	LedgerID  uint   `json:"ledger_id,omitempty"`
+}
+
+type InventoryService struct {
+	DB      *gorm.DB
+	SoldOut *SoldOutCache
+	Cache   *ProductCache
+}
+
+// 补货/调整库存：MySQL 行锁内算出差值，同一事务写库存与流水，
+// 最后以差值（而非覆盖）调整 Redis，Redis 失败则事务回滚，两边保持一致。
+// skuID 不为 0 时调整该规格的库存
+func (s *InventoryService) AdjustStock(productID, skuID uint, mode string, quantity int, reason, actor string) (*StockAdjustment, error) {
+	return s.adjust(productID, skuID, mode, quantity, reason, actor, nil)
+}
+
+// within 不为空时在同一事务内、调整 Redis 之前执行，返回错误则整体回滚
+func (s *InventoryService) adjust(productID, skuID uint, mode string, quantity int, reason, actor string,
+	within func(tx *gorm.DB, adj *StockAdjustment) error) (*StockAdjustment, error) {
+	switch mode {
+	case StockModeAdd:
+		if quantity <= 0 {
+			return nil, fmt.Errorf("quantity must be positive")
+		}
+	case StockModeSet:
+		if quantity < 0 {
+			return nil, fmt.Errorf("quantity must not be negative")
+		}
+	default:
+		return nil, fmt.Errorf("invalid mode %q", mode)
+	}
+
+	adj := &StockAdjustment{ProductID: productID, SKUID: skuID, Mode: mode}
+	applyRedis := func(delta int64) error {
+		if skuID != 0 {
+			return adjustSKUStock(skuID, delta)
+		}
+		return adjustStock(productID, delta)
+	}
+	redisApplied := false
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var product model.Product
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "stock").First(&product, productID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var bundled int64
+		if err := tx.Model(&model.BundleItem{}).Where("bundle_id = ?", productID).Count(&bundled).Error; err != nil {
+			return err
+		}
+		if bundled > 0 {
+			return fmt.Errorf("%w: adjust the component products instead", ErrInvalidBundle)
+		}
+
+		adj.Before = product.Stock
+		target := tx.Model(&model.Product{}).Where("id = ?", productID)
+		var ledgerSKU *uint
+		if skuID != 0 {
+			var sku model.SKU
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id", "stock").Where("product_id = ?", productID).First(&sku, skuID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSKUNotFound
+			}
+			if err != nil {
+				return err
+			}
+			adj.Before = sku.Stock
+			target = tx.Model(&model.SKU{}).Where("id = ?", skuID)
+			ledgerSKU = &skuID
+		}
+		if mode == StockModeAdd {
+			adj.Delta = quantity
+		} else {
+			adj.Delta = quantity - adj.Before
+		}
+		adj.After = adj.Before + adj.Delta
+		if adj.Delta == 0 {
+			return nil
+		}
+
+		err = target.Updates(map[string]interface{}{
+			"stock":       gorm.Expr("stock + ?", adj.Delta),
+			"total_stock": gorm.Expr("total_stock + ?", adj.Delta),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		ledgerType := model.LedgerRestock
+		if mode == StockModeSet {
+			ledgerType = model.LedgerAdjust
+		}
+		ledger := model.InventoryLedger{
+			ProductID: productID,
+			SKUID:     ledgerSKU,
+			Type:      ledgerType,
+			Quantity:  adj.Delta,
+			Before:    adj.Before,
+			After:     adj.After,
+			Actor:     actor,
+			Reason:    reason,
+		}
+		if err := tx.Create(&ledger).Error; err != nil {
+			return err
+		}
+		adj.LedgerID = ledger.ID
+		if within != nil {
+			if err := within(tx, adj); err != nil {
+				return err
+			}
+		}
+
+		// Redis 放在最后：失败时事务回滚
+		if err := applyRedis(int64(adj.Delta)); err != nil {
+			if err == ErrOutOfStock {
+				return fmt.Errorf("%w: not enough unsold stock in redis to reduce by %d", err, -adj.Delta)
+			}
+			return err
+		}
+		redisApplied = true
+		return nil
+	})
+	if err != nil {
+		if redisApplied {
+			// 事务提交失败，撤回已经生效的 Redis 调整
+			if rerr := applyRedis(int64(-adj.Delta)); rerr != nil {
+				log.Printf("⚠️ [库存调整回滚失败]: productID=%d, skuID=%d, delta=%d, err=%v", productID, skuID, adj.Delta, rerr)
+			}
+		}
+		return nil, err
+	}
+
+	if adj.Delta != 0 {
+		log.Printf("📦 [库存调整]: productID=%d, skuID=%d, mode=%s, %d -> %d, actor=%s, reason=%s",
+			productID, skuID, mode, adj.Before, adj.After, actor, reason)
+	}
+	if adj.Delta > 0 && skuID == 0 && s.SoldOut != nil {
+		s.SoldOut.Clear(productID)
+	}
+	if adj.Delta != 0 {
+		s.Cache.Invalidate()
+	}
+	return adj, nil
+}
+
+type LedgerQuery struct {
+	Type    string
+	OrderID uint
+	SKUID   uint
+	Since   time.Time
+	Until   time.Time
+	Page    int
+	Size    int
+}
+
+type LedgerPage struct {
+	Items []model.InventoryLedger `json:"items"`
+	Total int64                   `json:"total"`
+	Page  int                     `json:"page"`
+	Size  int                     `json:"size"`
+}
+
+// 按时间倒序分页查询商品的库存流水（含已归档商品）
+func (s *InventoryService) Ledger(productID uint, q LedgerQuery) (*LedgerPage, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Size < 1 || q.Size > 200 {
+		q.Size = 50
+	}
+
+	var count int64
+	if err := s.DB.Unscoped().Model(&model.Product{}).Where("id = ?", productID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrProductNotFound
+	}
+
+	query := s.DB.Model(&model.InventoryLedger{}).Where("product_id = ?", productID)
+	if q.Type != "" {
+		query = query.Where("type = ?", q.Type)
+	}
+	if q.OrderID != 0 {
+		query = query.Where("order_id = ?", q.OrderID)
+	}
+	if q.SKUID != 0 {
+		query = query.Where("sku_id = ?", q.SKUID)
+	}
+	if !q.Since.IsZero() {
+		query = query.Where("created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("created_at < ?", q.Until)
+	}
+
+	page := &LedgerPage{Items: []model.InventoryLedger{}, Page: q.Page, Size: q.Size}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	err := query.Order("id DESC").Offset((q.Page - 1) * q.Size).Limit(q.Size).Find(&page.Items).Error
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
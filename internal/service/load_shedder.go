@@ -0,0 +1,153 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	mqPkg "seckill-system/internal/pkg/mq"
+
+	"github.com/spf13/viper"
+)
+
+// 负载削峰状态
+type ShedState struct {
+	Enabled   bool      `json:"enabled"`
+	Shedding  bool      `json:"shedding"`
+	Reason    string    `json:"reason,omitempty"`
+	Depth     int       `json:"queue_depth"`
+	Consumers int       `json:"consumers"`
+	MaxDepth  int       `json:"max_queue_depth"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// 根据订单队列积压情况决定秒杀接口是否拒绝新请求
+type LoadShedder struct {
+	Enabled     bool
+	MaxDepth    int           // 队列深度达到该值开始削峰
+	ResumeDepth int           // 队列深度回落到该值以下恢复
+	Interval    time.Duration // 巡检间隔
+	RetryAfter  time.Duration // 建议客户端重试间隔
+
+	mu    sync.RWMutex
+	state ShedState
+	stop  chan struct{}
+}
+
+func NewLoadShedder() *LoadShedder {
+	l := &LoadShedder{
+		Enabled:     viper.GetBool("seckill.shed.enabled"),
+		MaxDepth:    viper.GetInt("seckill.shed.max_queue_depth"),
+		ResumeDepth: viper.GetInt("seckill.shed.resume_queue_depth"),
+		Interval:    viper.GetDuration("seckill.shed.check_interval"),
+		RetryAfter:  viper.GetDuration("seckill.shed.retry_after"),
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = 5000
+	}
+	if l.ResumeDepth <= 0 || l.ResumeDepth > l.MaxDepth {
+		l.ResumeDepth = l.MaxDepth * 8 / 10
+	}
+	if l.Interval <= 0 {
+		l.Interval = time.Second
+	}
+	if l.RetryAfter <= 0 {
+		l.RetryAfter = 5 * time.Second
+	}
+	l.state = ShedState{Enabled: l.Enabled, MaxDepth: l.MaxDepth}
+	return l
+}
+
+// 启动队列巡检协程
+func (l *LoadShedder) Start() {
+	if !l.Enabled {
+		log.Println("Load shedding disabled")
+		return
+	}
+	l.stop = make(chan struct{})
+	l.check()
+
+	go func() {
+		ticker := time.NewTicker(l.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.check()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	log.Printf("Load shedder started, max depth=%d, resume depth=%d", l.MaxDepth, l.ResumeDepth)
+}
+
+func (l *LoadShedder) Stop() {
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+}
+
+func (l *LoadShedder) check() {
+	q, err := mqPkg.InspectQueue()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.state.CheckedAt = time.Now()
+	if err != nil {
+		// 巡检失败时保持上一次的判断，发布失败会由秒杀流程自行回滚
+		l.state.Error = err.Error()
+		return
+	}
+	l.state.Error = ""
+	l.state.Depth = q.Messages
+	l.state.Consumers = q.Consumers
+
+	was := l.state.Shedding
+	switch {
+	case q.Consumers == 0 && q.Messages > 0:
+		l.state.Shedding = true
+		l.state.Reason = "no consumers"
+	case q.Messages >= l.MaxDepth:
+		l.state.Shedding = true
+		l.state.Reason = "queue depth over threshold"
+	case was && q.Messages > l.ResumeDepth:
+		// 回落到恢复水位以下才放行，避免在阈值附近来回抖动
+	default:
+		l.state.Shedding = false
+		l.state.Reason = ""
+	}
+
+	if was != l.state.Shedding {
+		if l.state.Shedding {
+			log.Printf("🚧 [削峰开启]: depth=%d, consumers=%d, reason=%s", q.Messages, q.Consumers, l.state.Reason)
+		} else {
+			log.Printf("✅ [削峰解除]: depth=%d, consumers=%d", q.Messages, q.Consumers)
+		}
+	}
+}
+
+func (l *LoadShedder) Shedding() bool {
+	if !l.Enabled {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state.Shedding
+}
+
+func (l *LoadShedder) RetryAfterSeconds() int {
+	if sec := int(l.RetryAfter.Round(time.Second) / time.Second); sec > 0 {
+		return sec
+	}
+	return 1
+}
+
+func (l *LoadShedder) State() ShedState {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state
+}
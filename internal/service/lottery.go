@@ -0,0 +1,580 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/lock"
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 抽签模式：商品登记在 product:lottery 中时不接受先到先得的秒杀请求。
+// 开奖规则公开可复算：每个报名按 sha256(种子 + ":" + 用户ID) 的十六进制串升序排名，
+// 依次跳过超出限购的用户，取前 N 名中签，N 为开奖时 Redis 中可取走的库存
+const lotteryProductsKey = "product:lottery"
+
+const lotteryAlgorithm = `rank entries by hex(sha256(seed + ":" + user_id)) ascending (ties by user_id); ` +
+	`skip users over their purchase limit; the first N remaining entries win, N = stock available at draw time`
+
+var (
+	ErrLotteryNotFound  = errors.New("lottery not found")
+	ErrLotteryOnly      = errors.New("product is sold by lottery, enter the lottery instead")
+	ErrLotteryNotOpen   = errors.New("lottery entry window is not open")
+	ErrLotteryNotClosed = errors.New("lottery entry window has not closed yet")
+	ErrLotteryDrawn     = errors.New("lottery already drawn")
+	ErrLotteryNotDrawn  = errors.New("lottery not drawn yet")
+	ErrAlreadyEntered   = errors.New("already entered this lottery")
+	ErrNotEntered       = errors.New("not entered this lottery")
+	ErrInvalidLottery   = errors.New("invalid lottery")
+	ErrLotteryDrawing   = errors.New("lottery draw already in progress")
+)
+
+// 用户在某次抽签中的排名依据
+func lotteryTicket(seed string, userID uint) string {
+	sum := sha256.Sum256([]byte(seed + ":" + strconv.FormatUint(uint64(userID), 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+func lotteryRequestID(lotteryID, userID uint) string {
+	return fmt.Sprintf("lottery-%d-%d", lotteryID, userID)
+}
+
+type LotteryService struct {
+	DB *gorm.DB
+}
+
+type LotteryParams struct {
+	ProductID  uint      `json:"product_id"`
+	Name       string    `json:"name"`
+	EntryStart time.Time `json:"entry_start"`
+	EntryEnd   time.Time `json:"entry_end"`
+}
+
+type LotteryView struct {
+	ID         uint       `json:"id"`
+	ProductID  uint       `json:"product_id"`
+	Name       string     `json:"name"`
+	EntryStart time.Time  `json:"entry_start"`
+	EntryEnd   time.Time  `json:"entry_end"`
+	Status     string     `json:"status"`
+	Entries    int64      `json:"entries"`
+	Winners    int        `json:"winners"`
+	Seed       string     `json:"seed,omitempty"` // 开奖后公开
+	DrawnAt    *time.Time `json:"drawn_at,omitempty"`
+}
+
+type LotteryResult struct {
+	LotteryID   uint   `json:"lottery_id"`
+	Status      string `json:"status"` // 抽签活动状态
+	Result      string `json:"result"` // pending, won, lost, ineligible, failed
+	OrderID     uint   `json:"order_id,omitempty"`
+	OrderStatus string `json:"order_status,omitempty"`
+}
+
+type LotteryAuditEntry struct {
+	UserID uint   `json:"user_id"`
+	Ticket string `json:"ticket"`
+	Result string `json:"result"`
+}
+
+// 开奖审计：按排名列出全部报名，Consistent 表示记录的结果与按种子复算的排名一致
+type LotteryAudit struct {
+	LotteryID  uint                `json:"lottery_id"`
+	Seed       string              `json:"seed"`
+	Algorithm  string              `json:"algorithm"`
+	Winners    int                 `json:"winners"`
+	Consistent bool                `json:"consistent"`
+	Entries    []LotteryAuditEntry `json:"entries"`
+}
+
+// 新建抽签：商品立即转为抽签模式，不再接受秒杀请求
+func (s *LotteryService) Create(params LotteryParams) (*LotteryView, error) {
+	if !params.EntryEnd.After(params.EntryStart) {
+		return nil, fmt.Errorf("%w: entry_end must be after entry_start", ErrInvalidLottery)
+	}
+	if !params.EntryEnd.After(time.Now()) {
+		return nil, fmt.Errorf("%w: entry_end must be in the future", ErrInvalidLottery)
+	}
+	lottery := &model.Lottery{
+		ProductID:  params.ProductID,
+		Name:       params.Name,
+		EntryStart: params.EntryStart,
+		EntryEnd:   params.EntryEnd,
+		Status:     model.LotteryOpen,
+	}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&model.Product{}, params.ProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+		// 开奖按商品库存取货，组合商品和有规格的商品没有商品级库存
+		var count int64
+		if err := tx.Model(&model.BundleItem{}).Where("bundle_id = ?", params.ProductID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			if err := tx.Model(&model.SKU{}).Where("product_id = ?", params.ProductID).Count(&count).Error; err != nil {
+				return err
+			}
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: bundles and products with skus cannot be sold by lottery", ErrInvalidLottery)
+		}
+		// 组合商品的组件会被组合秒杀按先到先得扣减
+		if err := tx.Model(&model.BundleItem{}).Where("product_id = ?", params.ProductID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: bundle components cannot be sold by lottery", ErrInvalidLottery)
+		}
+		return tx.Create(lottery).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := redisPkg.RDB.SAdd(redisPkg.Ctx, lotteryProductsKey, lottery.ProductID).Err(); err != nil {
+		return nil, err
+	}
+	log.Printf("🎟️ [抽签已创建]: lotteryID=%d, productID=%d, window=%s ~ %s",
+		lottery.ID, lottery.ProductID, lottery.EntryStart.Format(time.RFC3339), lottery.EntryEnd.Format(time.RFC3339))
+	return s.view(lottery)
+}
+
+func (s *LotteryService) get(id uint) (*model.Lottery, error) {
+	var lottery model.Lottery
+	err := s.DB.First(&lottery, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLotteryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lottery, nil
+}
+
+func (s *LotteryService) Get(id uint) (*LotteryView, error) {
+	lottery, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.view(lottery)
+}
+
+func (s *LotteryService) view(l *model.Lottery) (*LotteryView, error) {
+	v := &LotteryView{
+		ID:         l.ID,
+		ProductID:  l.ProductID,
+		Name:       l.Name,
+		EntryStart: l.EntryStart,
+		EntryEnd:   l.EntryEnd,
+		Status:     l.Status,
+		Winners:    l.Winners,
+		DrawnAt:    l.DrawnAt,
+	}
+	if l.Status == model.LotteryDrawn {
+		v.Seed = l.Seed
+	}
+	if err := s.DB.Model(&model.LotteryEntry{}).Where("lottery_id = ?", l.ID).Count(&v.Entries).Error; err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// 报名：只在报名窗口内接受，每人一次
+func (s *LotteryService) Enter(lotteryID, userID uint) error {
+	lottery, err := s.get(lotteryID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if lottery.Status != model.LotteryOpen || now.Before(lottery.EntryStart) || !now.Before(lottery.EntryEnd) {
+		return ErrLotteryNotOpen
+	}
+	var count int64
+	err = s.DB.Model(&model.LotteryEntry{}).Where("lottery_id = ? AND user_id = ?", lotteryID, userID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyEntered
+	}
+	// 并发重复报名由唯一索引拦截
+	return s.DB.Create(&model.LotteryEntry{LotteryID: lotteryID, UserID: userID, Result: model.EntryPending}).Error
+}
+
+type lotteryWinner struct {
+	entry *model.LotteryEntry
+	quota *quotaReservation
+}
+
+const lotteryDrawLockTTL = 2 * time.Minute
+
+// 开奖过程中在 Redis 已完成的操作：taken 为取走的库存，quota:{uid} 为已预占额度的 "活动组ID:日期"。
+// 进程在开奖中途崩溃后重新开奖时复用这些记录，不重复扣库存和额度，种子不变所以中签者不变
+func lotteryProgressKey(lotteryID uint) string {
+	return fmt.Sprintf("lottery:progress:%d", lotteryID)
+}
+
+func lotteryQuotaField(userID uint) string {
+	return fmt.Sprintf("quota:%d", userID)
+}
+
+// 开奖：种子为空时随机生成。先把活动置为开奖中（阻止继续报名），
+// 从 Redis 取走最多报名人数的库存，按排名逐个预占限购额度选出中签者，
+// 结果落库后为每个中签者投递下单消息，由 OrderConsumer 生成待支付订单。
+// 开奖中（上次开奖中途失败）的抽签可以再次开奖，沿用已记录的种子
+func (s *LotteryService) Draw(id uint, seed, actor string) (*LotteryView, error) {
+	if len(seed) > 64 {
+		return nil, fmt.Errorf("%w: seed must not exceed 64 characters", ErrInvalidLottery)
+	}
+	l, err := lock.Acquire(fmt.Sprintf("lottery:draw:%d", id), lotteryDrawLockTTL)
+	if errors.Is(err, lock.ErrNotAcquired) {
+		return nil, ErrLotteryDrawing
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	var lottery model.Lottery
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lottery, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrLotteryNotFound
+		}
+		if err != nil {
+			return err
+		}
+		switch lottery.Status {
+		case model.LotteryDrawing:
+			if seed != "" && seed != lottery.Seed {
+				return fmt.Errorf("%w: draw in progress with another seed, retry without seed", ErrInvalidLottery)
+			}
+			seed = lottery.Seed
+			log.Printf("🎟️ [继续未完成的开奖]: lotteryID=%d, seed=%s", id, seed)
+			return nil
+		case model.LotteryOpen:
+		default:
+			return ErrLotteryDrawn
+		}
+		if time.Now().Before(lottery.EntryEnd) {
+			return ErrLotteryNotClosed
+		}
+		if seed == "" {
+			seed = newRequestID()
+		}
+		lottery.Status = model.LotteryDrawing
+		lottery.Seed = seed
+		return tx.Model(&lottery).Updates(map[string]interface{}{"status": lottery.Status, "seed": seed}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	drawKey := lotteryProgressKey(id)
+	// 开奖失败时恢复为可重新开奖
+	reopen := func() {
+		err := s.DB.Model(&lottery).Updates(map[string]interface{}{"status": model.LotteryOpen, "seed": ""}).Error
+		if err != nil {
+			log.Printf("⚠️ [抽签状态恢复失败]: lotteryID=%d, err=%v", id, err)
+		}
+		redisPkg.RDB.Del(redisPkg.Ctx, drawKey)
+	}
+
+	var entries []model.LotteryEntry
+	if err := s.DB.Where("lottery_id = ?", id).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	ranked := rankEntries(seed, entries)
+
+	progress, err := redisPkg.RDB.HGetAll(redisPkg.Ctx, drawKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	taken, resumed := int64(0), false
+	if v, ok := progress["taken"]; ok {
+		taken, resumed = parseInt64(v), true
+	} else {
+		if taken, err = takeStock(lottery.ProductID, int64(len(ranked))); err != nil {
+			if taken > 0 {
+				restoreStock(lottery.ProductID, 0, taken)
+			}
+			reopen()
+			return nil, err
+		}
+		err := redisPkg.RDB.HSet(redisPkg.Ctx, drawKey, "taken", taken).Err()
+		if err == nil {
+			err = redisPkg.RDB.Expire(redisPkg.Ctx, drawKey, 24*time.Hour).Err()
+		}
+		if err != nil {
+			restoreStock(lottery.ProductID, 0, taken)
+			reopen()
+			return nil, err
+		}
+	}
+
+	winners := make([]lotteryWinner, 0, taken)
+	var ineligible []uint
+	rollback := func() {
+		for _, w := range winners {
+			if err := w.quota.release(); err != nil {
+				log.Printf("⚠️ [限购额度回滚失败]: userID=%d, err=%v", w.entry.UserID, err)
+			}
+		}
+		if taken > 0 {
+			if err := restoreStock(lottery.ProductID, 0, taken); err != nil {
+				log.Printf("⚠️ [库存回滚失败]: productID=%d, err=%v", lottery.ProductID, err)
+			}
+		}
+		reopen()
+	}
+	for i := range ranked {
+		if int64(len(winners)) == taken {
+			break
+		}
+		e := ranked[i].entry
+		if v, ok := progress[lotteryQuotaField(e.UserID)]; ok {
+			// 上次开奖已为该用户预占过额度
+			gid, day, _ := strings.Cut(v, ":")
+			winners = append(winners, lotteryWinner{entry: e, quota: &quotaReservation{
+				userID: e.UserID, productID: lottery.ProductID, groupID: uint(parseInt64(gid)), day: day, n: 1,
+			}})
+			continue
+		}
+		quota, err := reserveQuota(e.UserID, lottery.ProductID, 1)
+		if errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrReservationRequired) {
+			ineligible = append(ineligible, e.ID)
+			continue
+		}
+		if err == nil {
+			err = redisPkg.RDB.HSet(redisPkg.Ctx, drawKey, lotteryQuotaField(e.UserID),
+				fmt.Sprintf("%d:%s", quota.groupID, quota.day)).Err()
+			if err != nil {
+				quota.release()
+			}
+		}
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		winners = append(winners, lotteryWinner{entry: e, quota: quota})
+	}
+	// 中签人数不足（报名少或多人超限）时退回多取的库存；先记录再退回，中途崩溃最多少卖由对账补回
+	if left := taken - int64(len(winners)); left > 0 {
+		taken = int64(len(winners))
+		if err := redisPkg.RDB.HSet(redisPkg.Ctx, drawKey, "taken", taken).Err(); err != nil {
+			log.Printf("⚠️ [开奖进度记录失败]: lotteryID=%d, err=%v", id, err)
+		} else if err := restoreStock(lottery.ProductID, 0, left); err != nil {
+			log.Printf("⚠️ [库存回滚失败]: productID=%d, err=%v", lottery.ProductID, err)
+		}
+	}
+
+	now := time.Now()
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		for _, w := range winners {
+			reqID := lotteryRequestID(id, w.entry.UserID)
+			w.entry.Result = model.EntryWon
+			w.entry.RequestID = &reqID
+			err := tx.Model(w.entry).Updates(map[string]interface{}{"result": model.EntryWon, "request_id": reqID}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(ineligible) > 0 {
+			err := tx.Model(&model.LotteryEntry{}).Where("id IN ?", ineligible).Update("result", model.EntryIneligible).Error
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Model(&model.LotteryEntry{}).Where("lottery_id = ? AND result = ?", id, model.EntryPending).
+			Update("result", model.EntryLost).Error
+		if err != nil {
+			return err
+		}
+		// 锁过期被其他实例拿走时放弃提交，由持锁者完成开奖
+		if valid, err := l.Valid(); err != nil || !valid {
+			return ErrLotteryDrawing
+		}
+		return tx.Model(&lottery).Updates(map[string]interface{}{
+			"status":   model.LotteryDrawn,
+			"winners":  len(winners),
+			"drawn_at": now,
+			"drawn_by": actor,
+		}).Error
+	})
+	if errors.Is(err, ErrLotteryDrawing) {
+		return nil, err
+	}
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	redisPkg.RDB.Del(redisPkg.Ctx, drawKey)
+	log.Printf("🎟️ [抽签已开奖]: lotteryID=%d, entries=%d, winners=%d, seed=%s, resumed=%v",
+		id, len(entries), len(winners), seed, resumed)
+
+	s.dispatch(&lottery, winners)
+	if err := s.releaseProduct(lottery.ProductID); err != nil {
+		log.Printf("⚠️ [抽签模式解除失败]: productID=%d, err=%v", lottery.ProductID, err)
+	}
+	return s.Get(id)
+}
+
+// 为中签者投递下单消息，请求ID固定，消息重复投递时消费者去重；
+// 投递失败的名额退回库存与限购额度，结果记为 failed
+func (s *LotteryService) dispatch(lottery *model.Lottery, winners []lotteryWinner) {
+	for _, w := range winners {
+		body, err := json.Marshal(model.SeckillMessage{
+			UserID:    w.entry.UserID,
+			ProductID: lottery.ProductID,
+			Quantity:  1,
+			RequestID: *w.entry.RequestID,
+		})
+		if err == nil {
+			redisPkg.RDB.Incr(redisPkg.Ctx, inflightKey(lottery.ProductID))
+			if err = publishOrder(body); err != nil {
+				redisPkg.RDB.Decr(redisPkg.Ctx, inflightKey(lottery.ProductID))
+			}
+		}
+		if err == nil {
+			continue
+		}
+		log.Printf("⚠️ [中签下单投递失败]: lotteryID=%d, userID=%d, err=%v", lottery.ID, w.entry.UserID, err)
+		if rerr := restoreStock(lottery.ProductID, 0, 1); rerr != nil {
+			log.Printf("⚠️ [库存回滚失败]: productID=%d, err=%v", lottery.ProductID, rerr)
+		}
+		if rerr := w.quota.release(); rerr != nil {
+			log.Printf("⚠️ [限购额度回滚失败]: userID=%d, err=%v", w.entry.UserID, rerr)
+		}
+		s.DB.Model(w.entry).Update("result", model.EntryFailed)
+	}
+}
+
+// 商品没有未开奖的抽签后恢复为普通秒杀
+func (s *LotteryService) releaseProduct(productID uint) error {
+	var open int64
+	err := s.DB.Model(&model.Lottery{}).
+		Where("product_id = ? AND status <> ?", productID, model.LotteryDrawn).Count(&open).Error
+	if err != nil || open > 0 {
+		return err
+	}
+	return redisPkg.RDB.SRem(redisPkg.Ctx, lotteryProductsKey, productID).Err()
+}
+
+type rankedEntry struct {
+	entry  *model.LotteryEntry
+	ticket string
+}
+
+func rankEntries(seed string, entries []model.LotteryEntry) []rankedEntry {
+	ranked := make([]rankedEntry, len(entries))
+	for i := range entries {
+		ranked[i] = rankedEntry{entry: &entries[i], ticket: lotteryTicket(seed, entries[i].UserID)}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].ticket != ranked[j].ticket {
+			return ranked[i].ticket < ranked[j].ticket
+		}
+		return ranked[i].entry.UserID < ranked[j].entry.UserID
+	})
+	return ranked
+}
+
+// 用户的抽签结果，中签时附带订单（订单由消费者异步生成，可能稍后才出现）
+func (s *LotteryService) Result(lotteryID, userID uint) (*LotteryResult, error) {
+	lottery, err := s.get(lotteryID)
+	if err != nil {
+		return nil, err
+	}
+	var entry model.LotteryEntry
+	err = s.DB.Where("lottery_id = ? AND user_id = ?", lotteryID, userID).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotEntered
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &LotteryResult{LotteryID: lotteryID, Status: lottery.Status, Result: entry.Result}
+	if entry.RequestID != nil {
+		var order model.Order
+		err := s.DB.Select("id", "status").Where("request_id = ?", *entry.RequestID).First(&order).Error
+		if err == nil {
+			res.OrderID = order.ID
+			res.OrderStatus = order.Status
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// 按种子复算排名并核对记录的结果：中签（含投递失败）的报名之前不能有未中签的报名
+func (s *LotteryService) Audit(id uint) (*LotteryAudit, error) {
+	lottery, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if lottery.Status != model.LotteryDrawn {
+		return nil, ErrLotteryNotDrawn
+	}
+	var entries []model.LotteryEntry
+	if err := s.DB.Where("lottery_id = ?", id).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	audit := &LotteryAudit{
+		LotteryID:  id,
+		Seed:       lottery.Seed,
+		Algorithm:  lotteryAlgorithm,
+		Winners:    lottery.Winners,
+		Consistent: true,
+		Entries:    make([]LotteryAuditEntry, 0, len(entries)),
+	}
+	lostSeen, won := false, 0
+	for _, r := range rankEntries(lottery.Seed, entries) {
+		switch r.entry.Result {
+		case model.EntryWon, model.EntryFailed:
+			won++
+			if lostSeen {
+				audit.Consistent = false
+			}
+		case model.EntryLost:
+			lostSeen = true
+		case model.EntryPending:
+			audit.Consistent = false
+		}
+		audit.Entries = append(audit.Entries, LotteryAuditEntry{UserID: r.entry.UserID, Ticket: r.ticket, Result: r.entry.Result})
+	}
+	if won != lottery.Winners {
+		audit.Consistent = false
+	}
+	return audit, nil
+}
+
+// 回灌抽签模式的商品
+func syncLotteryProducts(db *gorm.DB) error {
+	var ids []uint
+	err := db.Model(&model.Lottery{}).Distinct("product_id").
+		Where("status <> ?", model.LotteryDrawn).Pluck("product_id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	return redisPkg.RDB.SAdd(redisPkg.Ctx, lotteryProductsKey, members...).Err()
+}
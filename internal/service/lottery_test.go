@@ -0,0 +1,100 @@
+package service
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"seckill-system/internal/model"
+)
+
+func TestLotteryTicket(t *testing.T) {
+	tests := []struct {
+		seed   string
+		userID uint
+		want   string
+	}{
+		{"seed-1", 1, "06656c9c44b44d22f15616d56ee8e1c3559d66c380a98646c0234aaddf8cbf8e"},
+		{"seed-1", 2, "71a8ee4d4a644d881c3973d9f77e9ada77c47f0ecebc1de8e3f47e95c7875a90"},
+		{"seed-1", 42, "405f25b067de1c8228c63fe8a3c8380901cacf5a62295cfd5edc3448e37e0e35"},
+		{"", 7, "9ba402202d1e3b63a6e5d0b03999df2f9b5199bfab0ec0801133e8d34d73891b"},
+	}
+	for _, tt := range tests {
+		if got := lotteryTicket(tt.seed, tt.userID); got != tt.want {
+			t.Errorf("lotteryTicket(%q, %d) = %s, want %s", tt.seed, tt.userID, got, tt.want)
+		}
+	}
+}
+
+func rankedUsers(ranked []rankedEntry) []uint {
+	ids := make([]uint, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.entry.UserID
+	}
+	return ids
+}
+
+func lotteryEntries(userIDs ...uint) []model.LotteryEntry {
+	entries := make([]model.LotteryEntry, len(userIDs))
+	for i, id := range userIDs {
+		entries[i] = model.LotteryEntry{ID: uint(i + 1), UserID: id}
+	}
+	return entries
+}
+
+func TestRankEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		seed  string
+		users []uint
+		want  []uint
+	}{
+		{"empty", "seed-1", nil, []uint{}},
+		{"single", "seed-1", []uint{2}, []uint{2}},
+		// 按票号升序：06656c…(1) < 405f25…(42) < 71a8ee…(2)
+		{"by ticket", "seed-1", []uint{2, 42, 1}, []uint{1, 42, 2}},
+		{"input order does not matter", "seed-1", []uint{1, 2, 42}, []uint{1, 42, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked := rankEntries(tt.seed, lotteryEntries(tt.users...))
+			if got := rankedUsers(ranked); !slices.Equal(got, tt.want) {
+				t.Errorf("rankEntries = %v, want %v", got, tt.want)
+			}
+			for _, r := range ranked {
+				if r.ticket != lotteryTicket(tt.seed, r.entry.UserID) {
+					t.Errorf("user %d ticket = %s, want lotteryTicket", r.entry.UserID, r.ticket)
+				}
+			}
+		})
+	}
+}
+
+// 同一种子无论报名顺序如何排名都相同，审计可以复算
+func TestRankEntriesDeterministic(t *testing.T) {
+	users := make([]uint, 200)
+	for i := range users {
+		users[i] = uint(i + 1)
+	}
+	want := rankedUsers(rankEntries("audit-seed", lotteryEntries(users...)))
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5; i++ {
+		shuffled := slices.Clone(users)
+		rng.Shuffle(len(shuffled), func(a, b int) { shuffled[a], shuffled[b] = shuffled[b], shuffled[a] })
+		if got := rankedUsers(rankEntries("audit-seed", lotteryEntries(shuffled...))); !slices.Equal(got, want) {
+			t.Fatalf("shuffle %d: ranking changed with input order", i)
+		}
+	}
+
+	ranked := rankEntries("audit-seed", lotteryEntries(users...))
+	for i := 1; i < len(ranked); i++ {
+		if ranked[i-1].ticket > ranked[i].ticket {
+			t.Fatalf("tickets not ascending at %d: %s > %s", i, ranked[i-1].ticket, ranked[i].ticket)
+		}
+	}
+
+	if other := rankedUsers(rankEntries("other-seed", lotteryEntries(users...))); slices.Equal(other, want) {
+		t.Error("different seeds produced the same ranking")
+	}
+}
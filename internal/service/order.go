@@ -0,0 +1,266 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOrderNotFound  = errors.New("order not found")
+	ErrOrderCancelled = errors.New("order already cancelled")
+)
+
+type OrderService struct {
+	DB      *gorm.DB
+	SoldOut *SoldOutCache
+}
+
+// 对外展示的订单，含下单时的商品快照
+type OrderView struct {
+	ID           uint        `json:"id"`
+	UserID       uint        `json:"user_id"`
+	ProductID    uint        `json:"product_id"`
+	ProductName  string      `json:"product_name"`
+	SKUID        *uint       `json:"sku_id,omitempty"`
+	SKUName      string      `json:"sku_name,omitempty"`
+	Quantity     int         `json:"quantity"`
+	UnitPrice    money.Money `json:"unit_price"`
+	SeckillPrice money.Money `json:"seckill_price"`
+	Total        money.Money `json:"total"`
+	Status       string      `json:"status"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+
+	Items []OrderItemView `json:"items,omitempty"` // 组合商品订单的组件明细
+}
+
+type OrderItemView struct {
+	ProductID   uint        `json:"product_id"`
+	ProductName string      `json:"product_name"`
+	Quantity    int         `json:"quantity"`
+	UnitPrice   money.Money `json:"unit_price"`
+}
+
+func newOrderView(o *model.Order) OrderView {
+	return OrderView{
+		ID:           o.ID,
+		UserID:       o.UserID,
+		ProductID:    o.ProductID,
+		ProductName:  o.ProductName,
+		SKUID:        o.SKUID,
+		SKUName:      o.SKUName,
+		Quantity:     o.Quantity,
+		UnitPrice:    o.UnitPrice,
+		SeckillPrice: o.SeckillPrice,
+		Total:        o.Total,
+		Status:       o.Status,
+		CreatedAt:    o.CreatedAt,
+		UpdatedAt:    o.UpdatedAt,
+	}
+}
+
+type OrderQuery struct {
+	UserID    uint
+	ProductID uint
+	Status    string
+	Page      int
+	Size      int
+}
+
+type OrderPage struct {
+	Items []OrderView `json:"items"`
+	Total int64       `json:"total"`
+	Page  int         `json:"page"`
+	Size  int         `json:"size"`
+}
+
+func (s *OrderService) Get(id uint) (*OrderView, error) {
+	var order model.Order
+	err := s.DB.First(&order, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	views := []OrderView{newOrderView(&order)}
+	if err := s.attachItems(views, []model.Order{order}); err != nil {
+		return nil, err
+	}
+	return &views[0], nil
+}
+
+// 为组合商品订单附上组件明细
+func (s *OrderService) attachItems(views []OrderView, orders []model.Order) error {
+	var ids []uint
+	for _, o := range orders {
+		if o.Bundle {
+			ids = append(ids, o.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var lines []model.OrderItem
+	if err := s.DB.Where("order_id IN ?", ids).Order("order_id, product_id").Find(&lines).Error; err != nil {
+		return err
+	}
+	byOrder := make(map[uint][]OrderItemView, len(ids))
+	for _, l := range lines {
+		byOrder[l.OrderID] = append(byOrder[l.OrderID], OrderItemView{
+			ProductID:   l.ProductID,
+			ProductName: l.ProductName,
+			Quantity:    l.Quantity,
+			UnitPrice:   l.UnitPrice,
+		})
+	}
+	for i := range views {
+		views[i].Items = byOrder[views[i].ID]
+	}
+	return nil
+}
+
+// 按下单时间倒序分页查询订单
+func (s *OrderService) List(q OrderQuery) (*OrderPage, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Size < 1 {
+		q.Size = defaultPageSize
+	}
+	if q.Size > maxPageSize {
+		q.Size = maxPageSize
+	}
+
+	query := s.DB.Model(&model.Order{})
+	if q.UserID != 0 {
+		query = query.Where("user_id = ?", q.UserID)
+	}
+	if q.ProductID != 0 {
+		query = query.Where("product_id = ?", q.ProductID)
+	}
+	if q.Status != "" {
+		query = query.Where("status = ?", q.Status)
+	}
+
+	page := &OrderPage{Items: []OrderView{}, Page: q.Page, Size: q.Size}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	var orders []model.Order
+	err := query.Order("id DESC").Offset((q.Page - 1) * q.Size).Limit(q.Size).Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		page.Items = append(page.Items, newOrderView(&orders[i]))
+	}
+	if err := s.attachItems(page.Items, orders); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// 取消订单：同一事务内改状态、退回 MySQL 库存并记流水，提交后再退回 Redis 库存与购买标记
+func (s *OrderService) Cancel(orderID uint, actor, reason string) (*OrderView, error) {
+	var (
+		order    model.Order
+		archived bool
+		parts    []bundleComponent // 组合商品订单需要退回 Redis 的组件
+	)
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrOrderNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if order.Status == model.OrderCancelled {
+			return ErrOrderCancelled
+		}
+
+		// 已归档的商品同样退回库存，保证流水与对账连续
+		var product model.Product
+		err = tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "stock", "deleted_at").First(&product, order.ProductID).Error
+		if err != nil {
+			return err
+		}
+		archived = product.DeletedAt.Valid
+
+		if err := tx.Model(&order).Update("status", model.OrderCancelled).Error; err != nil {
+			return err
+		}
+		if order.Bundle {
+			parts, err = restoreBundleComponents(tx, &order, actor, reason)
+			return err
+		}
+
+		// 规格订单退回规格库存
+		before := product.Stock
+		restore := tx.Unscoped().Model(&model.Product{}).Where("id = ?", order.ProductID)
+		if order.SKUID != nil {
+			var sku model.SKU
+			err = tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id", "stock").First(&sku, *order.SKUID).Error
+			if err != nil {
+				return err
+			}
+			before = sku.Stock
+			restore = tx.Unscoped().Model(&model.SKU{}).Where("id = ?", sku.ID)
+		}
+		if err := restore.Update("stock", gorm.Expr("stock + ?", order.Quantity)).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.InventoryLedger{
+			ProductID: order.ProductID,
+			SKUID:     order.SKUID,
+			Type:      model.LedgerCancel,
+			Quantity:  order.Quantity,
+			Before:    before,
+			After:     before + order.Quantity,
+			OrderID:   &order.ID,
+			Actor:     actor,
+			Reason:    reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	order.Status = model.OrderCancelled
+	log.Printf("↩️ [订单已取消]: orderID=%d, productID=%d, actor=%s", order.ID, order.ProductID, actor)
+
+	view := newOrderView(&order)
+	// Redis 退回失败不影响取消结果，由对账修复；组合商品的组件是否归档已逐个判断
+	n := int64(order.Quantity)
+	if order.Bundle {
+		restoreBundleStock(parts, 1)
+	}
+	if archived {
+		return &view, nil
+	}
+	if order.SKUID != nil {
+		if err := restoreSKUStock(*order.SKUID, n); err != nil {
+			log.Printf("⚠️ [规格库存回滚失败]: skuID=%d, err=%v", *order.SKUID, err)
+		}
+	} else if !order.Bundle {
+		if err := restoreStock(order.ProductID, 0, n); err != nil {
+			log.Printf("⚠️ [库存回滚失败]: productID=%d, err=%v", order.ProductID, err)
+		}
+	}
+	if err := releaseQuota(order.UserID, order.ProductID, order.CampaignGroupID, n); err != nil {
+		log.Printf("⚠️ [限购额度回滚失败]: userID=%d, productID=%d, err=%v", order.UserID, order.ProductID, err)
+	}
+	if s.SoldOut != nil {
+		s.SoldOut.Clear(order.ProductID)
+	}
+	return &view, nil
+}
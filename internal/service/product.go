@@ -1,57 +1,501 @@
 package service
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/money"
 	redisPkg "seckill-system/internal/pkg/redis"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type ProductService struct {
-	DB *gorm.DB
+	DB    *gorm.DB
+	Cache *ProductCache
 }
 
-func (s *ProductService) Create(name string, stock int, price float64) error {
+var ErrInvalidPrice = errors.New("invalid price")
+
+// 秒杀价需与原价同币种且不高于原价，金额为 0 表示不设秒杀价
+func checkSeckillPrice(price, seckillPrice money.Money) error {
+	if seckillPrice.Amount == 0 {
+		return nil
+	}
+	if seckillPrice.Currency != price.Currency {
+		return fmt.Errorf("%w: seckill price currency %s differs from price currency %s",
+			ErrInvalidPrice, seckillPrice.Currency, price.Currency)
+	}
+	if seckillPrice.Amount > price.Amount {
+		return fmt.Errorf("%w: seckill price %s exceeds price %s", ErrInvalidPrice, seckillPrice, price)
+	}
+	return nil
+}
+
+// 新建商品的参数
+type ProductParams struct {
+	Name          string
+	Stock         int
+	Price         money.Money
+	SeckillPrice  money.Money // 金额为 0 表示不设秒杀价
+	Shards        int         // 库存分片数，默认 1
+	PurchaseLimit int         // 每人限购件数，0 表示使用默认配置
+}
+
+func (s *ProductService) Create(params ProductParams, actor string) error {
+	if err := checkSeckillPrice(params.Price, params.SeckillPrice); err != nil {
+		return err
+	}
+	shards := max(params.Shards, 1)
+	if shards > maxStockShards {
+		return fmt.Errorf("shards must not exceed %d", maxStockShards)
+	}
+	stock := params.Stock
 	product := model.Product{
-		Name:  name,
-		Stock: stock,
-		Price: price,
+		Name:          params.Name,
+		Stock:         stock,
+		TotalStock:    stock,
+		Price:         params.Price,
+		SeckillPrice:  params.SeckillPrice,
+		StockShards:   shards,
+		PurchaseLimit: params.PurchaseLimit,
 	}
-	if err := s.DB.Create(&product).Error; err != nil {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.InventoryLedger{
+			ProductID: product.ID,
+			Type:      model.LedgerInit,
+			Quantity:  stock,
+			Before:    0,
+			After:     stock,
+			Actor:     actor,
+		}).Error
+	})
+	if err != nil {
 		return err
 	}
 
-	//把库存写入redis
-	redisKey := fmt.Sprintf("stock:%d", product.ID)
-	redisPkg.RDB.Set(redisPkg.Ctx, redisKey, stock, 0)
+	//把库存写入redis（分片商品拆到各子key）
+	if _, err := initStock(product.ID, stock, shards, false); err != nil {
+		return err
+	}
 
+	//登记商品ID，秒杀前用于校验商品是否存在
+	if err := registerProducts(product.ID); err != nil {
+		return err
+	}
+
+	//登记每人限购件数
+	setPurchaseLimit(redisPkg.RDB, product.ID, product.PurchaseLimit)
+
+	s.Cache.Invalidate()
 	return nil
 }
 
-func (s *ProductService) List() ([]model.Product, error) {
-	var products []model.Product
-	err := s.DB.Find(&products).Error
-	return products, err
+func (s *ProductService) Get(id uint) (*model.Product, error) {
+	var product model.Product
+	err := s.DB.First(&product, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
 }
 
-// 回灌DB库存到redis
-func (s *ProductService) SyncStockToRedis() error {
-	var products []model.Product
-	if err := s.DB.Select("id", "stock").Find(&products).Error; err != nil {
+// 经缓存读取商品，供对外查询接口使用；修改类操作仍用 Get 直接读库
+func (s *ProductService) Cached(id uint) (*model.Product, error) {
+	return s.Cache.Product(id, func() (*model.Product, error) {
+		return s.Get(id)
+	})
+}
+
+// 商品详情，字段与列表一致并叠加实时库存
+func (s *ProductService) Detail(id uint) (*ProductItem, error) {
+	product, err := s.Cached(id)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.productItems([]model.Product{*product})
+	if err != nil {
+		return nil, err
+	}
+	return &items[0], nil
+}
+
+// 修改商品的参数，为 nil 的字段保持不变
+type ProductUpdate struct {
+	Name            *string
+	Price           *money.Money
+	SeckillPrice    *money.Money
+	PurchaseLimit   *int
+	CampaignGroupID *uint // 0 表示移出活动组
+}
+
+func (s *ProductService) Update(id uint, u ProductUpdate) (*model.Product, error) {
+	product, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if u.Name != nil {
+		updates["name"] = *u.Name
+		product.Name = *u.Name
+	}
+	if u.Price != nil {
+		updates["price_amount"] = u.Price.Amount
+		updates["price_currency"] = u.Price.Currency
+		product.Price = *u.Price
+	}
+	if u.SeckillPrice != nil {
+		updates["seckill_price_amount"] = u.SeckillPrice.Amount
+		updates["seckill_price_currency"] = u.SeckillPrice.Currency
+		product.SeckillPrice = *u.SeckillPrice
+	}
+	if u.PurchaseLimit != nil {
+		updates["purchase_limit"] = *u.PurchaseLimit
+		product.PurchaseLimit = *u.PurchaseLimit
+	}
+	if u.CampaignGroupID != nil {
+		product.CampaignGroupID = nil
+		if *u.CampaignGroupID != 0 {
+			if err := s.DB.First(&model.CampaignGroup{}, *u.CampaignGroupID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, ErrCampaignGroupNotFound
+				}
+				return nil, err
+			}
+			product.CampaignGroupID = u.CampaignGroupID
+		}
+		updates["campaign_group_id"] = product.CampaignGroupID
+	}
+	if u.Price != nil || u.SeckillPrice != nil {
+		if err := checkSeckillPrice(product.Price, product.SeckillPrice); err != nil {
+			return nil, err
+		}
+	}
+	if len(updates) > 0 {
+		if err := s.DB.Model(product).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+		if u.PurchaseLimit != nil {
+			setPurchaseLimit(redisPkg.RDB, id, *u.PurchaseLimit)
+		}
+		if u.CampaignGroupID != nil {
+			setProductGroup(redisPkg.RDB, id, product.CampaignGroupID)
+		}
+		s.Cache.Invalidate()
+	}
+	return product, nil
+}
+
+// 上架/下架，下架后秒杀直接拒绝
+func (s *ProductService) SetStatus(id uint, status string) (*model.Product, error) {
+	if status != model.ProductOnShelf && status != model.ProductOffShelf {
+		return nil, fmt.Errorf("invalid status %q", status)
+	}
+	product, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.Model(product).Update("status", status).Error; err != nil {
+		return nil, err
+	}
+	product.Status = status
+	s.Cache.Invalidate()
+	if err := setOffShelf(id, status == model.ProductOffShelf); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// 删除（归档）商品：软删除保留历史订单关联，并清理 Redis 中的库存key
+func (s *ProductService) Delete(id uint) error {
+	product, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := s.DB.Delete(product).Error; err != nil {
 		return err
 	}
+	s.Cache.Invalidate()
+	var skuIDs []uint
+	if err := s.DB.Model(&model.SKU{}).Where("product_id = ?", id).Pluck("id", &skuIDs).Error; err != nil {
+		return err
+	}
+	if err := removeSKUStock(id, skuIDs); err != nil {
+		return err
+	}
+	if err := redisPkg.RDB.Del(redisPkg.Ctx, bundleItemsKey(id)).Err(); err != nil {
+		return err
+	}
+	bundles.Remove(id)
+	return removeStock(id, product.StockShards)
+}
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// 列表可排序字段，前缀 - 表示倒序
+var productSorts = map[string]string{
+	"id":         "id",
+	"price":      "price_amount",
+	"created_at": "created_at",
+}
+
+type ProductQuery struct {
+	Name     string       // 名称包含
+	MinPrice *money.Money // 价格下限（含），只匹配同币种商品
+	MaxPrice *money.Money // 价格上限（含），只匹配同币种商品
+	InStock  bool         // 只返回有货商品，按 MySQL 库存判断（可能略滞后于 Redis 实时库存）
+	Status   string
+	Sort     string // id, price, created_at，前缀 - 倒序
+	Cursor   string // 不为空时按游标翻页，忽略 Page
+	Page     int
+	Size     int
+}
+
+// 对外展示的商品字段，Stock 为 Redis 中的实时可售库存
+type ProductItem struct {
+	ID           uint        `json:"id"`
+	Name         string      `json:"name"`
+	Price        money.Money `json:"price"`
+	SeckillPrice money.Money `json:"seckill_price"`
+	Stock        int64       `json:"stock"`
+	Status       string      `json:"status"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+// 一页商品在 MySQL 中的数据，可缓存；实时库存在返回前再叠加
+type productRows struct {
+	Products   []model.Product `json:"products"`
+	Total      *int64          `json:"total,omitempty"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type ProductPage struct {
+	Items      []ProductItem `json:"items"`
+	Total      *int64        `json:"total,omitempty"` // 仅分页模式返回
+	Page       int           `json:"page,omitempty"`
+	Size       int           `json:"size"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// 游标记录上一页最后一条的排序值与ID
+type productCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// 分页、过滤、排序查询商品；支持 offset 分页与游标分页两种方式
+func (s *ProductService) List(q ProductQuery) (*ProductPage, error) {
+	if q.Size < 1 {
+		q.Size = defaultPageSize
+	}
+	if q.Size > maxPageSize {
+		q.Size = maxPageSize
+	}
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	desc := strings.HasPrefix(q.Sort, "-")
+	column, ok := productSorts[strings.TrimPrefix(q.Sort, "-")]
+	if !ok {
+		return nil, fmt.Errorf("%w: sort %q", ErrInvalidQuery, q.Sort)
+	}
+	if q.Status != "" && q.Status != model.ProductOnShelf && q.Status != model.ProductOffShelf {
+		return nil, fmt.Errorf("%w: status %q", ErrInvalidQuery, q.Status)
+	}
+	if q.Cursor == "" && q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Cursor != "" {
+		q.Page = 0
+	}
+
+	rows, err := s.Cache.List(q, func() (*productRows, error) {
+		return s.queryProducts(q, column, desc)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.productItems(rows.Products)
+	if err != nil {
+		return nil, err
+	}
+	return &ProductPage{
+		Items:      items,
+		Total:      rows.Total,
+		Page:       q.Page,
+		Size:       q.Size,
+		NextCursor: rows.NextCursor,
+	}, nil
+}
+
+// 转换为对外展示的字段并叠加 Redis 实时库存
+func (s *ProductService) productItems(products []model.Product) ([]ProductItem, error) {
+	live, err := liveStock(products)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.overlaySKUStock(products, live); err != nil {
+		return nil, err
+	}
+	if err := s.overlayBundleStock(products, live); err != nil {
+		return nil, err
+	}
+	items := make([]ProductItem, 0, len(products))
 	for _, p := range products {
-		key := fmt.Sprintf("stock:%d", p.ID)
-		exits, err := redisPkg.RDB.Exists(redisPkg.Ctx, key).Result()
-		if err != nil {
-			return err
+		stock, ok := live[p.ID]
+		if !ok {
+			// Redis 未同步时退回 MySQL 库存
+			stock = int64(p.Stock)
 		}
-		if exits == 0 {
-			if err := redisPkg.RDB.Set(redisPkg.Ctx, key, p.Stock, 0).Err(); err != nil {
-				return err
+		items = append(items, ProductItem{
+			ID:           p.ID,
+			Name:         p.Name,
+			Price:        p.Price,
+			SeckillPrice: p.SeckillPrice,
+			Stock:        stock,
+			Status:       p.Status,
+			CreatedAt:    p.CreatedAt,
+		})
+	}
+	return items, nil
+}
+
+// 按已校验的查询条件从 MySQL 读取一页
+func (s *ProductService) queryProducts(q ProductQuery, column string, desc bool) (*productRows, error) {
+	query := s.DB.Model(&model.Product{})
+	if q.Name != "" {
+		query = query.Where("name LIKE ?", "%"+escapeLike(q.Name)+"%")
+	}
+	if q.MinPrice != nil {
+		query = query.Where("price_currency = ? AND price_amount >= ?", q.MinPrice.Currency, q.MinPrice.Amount)
+	}
+	if q.MaxPrice != nil {
+		query = query.Where("price_currency = ? AND price_amount <= ?", q.MaxPrice.Currency, q.MaxPrice.Amount)
+	}
+	if q.Status != "" {
+		query = query.Where("status = ?", q.Status)
+	}
+	if q.InStock {
+		// 在查询中完成过滤，total 与游标才和返回的条目一致；
+		// 组合商品要求每个组件的库存都够一套
+		query = query.Where("stock > 0 OR EXISTS (SELECT 1 FROM skus WHERE skus.product_id = products.id " +
+			"AND skus.stock > 0 AND skus.deleted_at IS NULL) " +
+			"OR (EXISTS (SELECT 1 FROM bundle_items WHERE bundle_items.bundle_id = products.id) " +
+			"AND NOT EXISTS (SELECT 1 FROM bundle_items JOIN products AS c ON c.id = bundle_items.product_id " +
+			"WHERE bundle_items.bundle_id = products.id AND c.stock < bundle_items.quantity))")
+	}
+
+	rows := &productRows{}
+	if q.Cursor == "" {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return nil, err
+		}
+		rows.Total = &total
+		query = query.Offset((q.Page - 1) * q.Size)
+	} else {
+		cur, err := decodeCursor(q.Cursor)
+		if err != nil || cur.Sort != q.Sort {
+			return nil, fmt.Errorf("%w: cursor", ErrInvalidQuery)
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		var value interface{}
+		switch column {
+		case "created_at":
+			t, err := time.Parse(time.RFC3339Nano, cur.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: cursor", ErrInvalidQuery)
 			}
+			value = t
+		case "price_amount":
+			amount, err := strconv.ParseInt(cur.Value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: cursor", ErrInvalidQuery)
+			}
+			value = amount
+		}
+		if column == "id" {
+			query = query.Where("id "+op+" ?", cur.ID)
+		} else {
+			query = query.Where("("+column+" "+op+" ?) OR ("+column+" = ? AND id "+op+" ?)", value, value, cur.ID)
 		}
 	}
-	return nil
+
+	dir := " ASC"
+	if desc {
+		dir = " DESC"
+	}
+	order := column + dir
+	if column != "id" {
+		order += ", id" + dir
+	}
+
+	// 多取一条判断是否还有下一页
+	var products []model.Product
+	err := query.Select("id", "name", "price_amount", "price_currency",
+		"seckill_price_amount", "seckill_price_currency", "stock", "stock_shards", "status", "created_at").
+		Order(order).Limit(q.Size + 1).Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+	hasMore := len(products) > q.Size
+	if hasMore {
+		products = products[:q.Size]
+	}
+	if hasMore && len(products) > 0 {
+		rows.NextCursor = encodeCursor(q.Sort, column, products[len(products)-1])
+	}
+	rows.Products = products
+	return rows, nil
+}
+
+func encodeCursor(sort, column string, p model.Product) string {
+	cur := productCursor{Sort: sort, ID: p.ID}
+	switch column {
+	case "price_amount":
+		cur.Value = strconv.FormatInt(p.Price.Amount, 10)
+	case "created_at":
+		cur.Value = p.CreatedAt.Format(time.RFC3339Nano)
+	}
+	body, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+func decodeCursor(s string) (*productCursor, error) {
+	body, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur productCursor
+	if err := json.Unmarshal(body, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// 转义 LIKE 通配符，名称中的 % 和 _ 按字面匹配
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"seckill-system/internal/model"
+	redisPkg "seckill-system/internal/pkg/redis"
+	"seckill-system/internal/pkg/singleflight"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+// 商品目录缓存（cache-aside）：读未命中时回源 MySQL 并写回 Redis。
+// 所有缓存key都带目录版本号，商品变更时递增版本号即整体失效：
+// 既不用枚举列表页的key，也避免了回源期间发生更新导致旧数据被写回新key。
+const (
+	productCacheVersionKey = "cache:products:version"
+	productCacheMissing    = "-" // 负缓存标记：商品不存在
+)
+
+type ProductCacheStats struct {
+	Enabled      bool    `json:"enabled"`
+	Hits         int64   `json:"hits"`
+	Misses       int64   `json:"misses"`
+	NegativeHits int64   `json:"negative_hits"` // 命中负缓存（商品不存在）
+	Coalesced    int64   `json:"coalesced"`     // 未命中但与其他请求合并回源
+	HitRate      float64 `json:"hit_rate"`
+}
+
+type ProductCache struct {
+	Enabled     bool
+	TTL         time.Duration
+	Jitter      time.Duration // TTL 随机增加 [0, Jitter)，避免大量key同时过期
+	NegativeTTL time.Duration
+
+	group        singleflight.Group
+	hits         atomic.Int64
+	misses       atomic.Int64
+	negativeHits atomic.Int64
+	coalesced    atomic.Int64
+}
+
+func NewProductCache() *ProductCache {
+	c := &ProductCache{
+		Enabled:     viper.GetBool("seckill.cache.enabled"),
+		TTL:         viper.GetDuration("seckill.cache.ttl"),
+		Jitter:      viper.GetDuration("seckill.cache.jitter"),
+		NegativeTTL: viper.GetDuration("seckill.cache.negative_ttl"),
+	}
+	if c.TTL <= 0 {
+		c.TTL = time.Minute
+	}
+	if c.NegativeTTL <= 0 {
+		c.NegativeTTL = 5 * time.Second
+	}
+	return c
+}
+
+// 按ID读取商品，不存在时返回 ErrProductNotFound
+func (c *ProductCache) Product(id uint, load func() (*model.Product, error)) (*model.Product, error) {
+	if c == nil || !c.Enabled {
+		return load()
+	}
+	v, err := c.fetch(fmt.Sprintf("product:%d", id), func() (interface{}, error) {
+		return load()
+	}, func(body []byte) (interface{}, error) {
+		var p model.Product
+		err := json.Unmarshal(body, &p)
+		return &p, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*model.Product), nil
+}
+
+// 读取一页商品列表，按规范化后的查询条件缓存
+func (c *ProductCache) List(q ProductQuery, load func() (*productRows, error)) (*productRows, error) {
+	if c == nil || !c.Enabled {
+		return load()
+	}
+	body, _ := json.Marshal(q)
+	sum := sha1.Sum(body)
+	v, err := c.fetch("products:"+hex.EncodeToString(sum[:]), func() (interface{}, error) {
+		return load()
+	}, func(body []byte) (interface{}, error) {
+		var rows productRows
+		err := json.Unmarshal(body, &rows)
+		return &rows, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*productRows), nil
+}
+
+// 商品新增、修改、上下架、删除、库存调整后调用
+func (c *ProductCache) Invalidate() {
+	if c == nil || !c.Enabled {
+		return
+	}
+	if err := redisPkg.RDB.Incr(redisPkg.Ctx, productCacheVersionKey).Err(); err != nil {
+		log.Printf("⚠️ [商品缓存失效失败]: err=%v", err)
+	}
+}
+
+func (c *ProductCache) Stats() ProductCacheStats {
+	if c == nil {
+		return ProductCacheStats{}
+	}
+	s := ProductCacheStats{
+		Enabled:      c.Enabled,
+		Hits:         c.hits.Load(),
+		Misses:       c.misses.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Coalesced:    c.coalesced.Load(),
+	}
+	if total := s.Hits + s.NegativeHits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits+s.NegativeHits) / float64(total)
+	}
+	return s
+}
+
+// 读缓存，未命中时同一key只有一个请求回源；Redis 故障时直接回源
+func (c *ProductCache) fetch(name string, load func() (interface{}, error), decode func([]byte) (interface{}, error)) (interface{}, error) {
+	version, err := redisPkg.RDB.Get(redisPkg.Ctx, productCacheVersionKey).Result()
+	if err == redis.Nil {
+		version = "0"
+	} else if err != nil {
+		c.misses.Add(1)
+		return load()
+	}
+	key := fmt.Sprintf("cache:v%s:%s", version, name)
+
+	body, err := redisPkg.RDB.Get(redisPkg.Ctx, key).Bytes()
+	if err == nil {
+		if string(body) == productCacheMissing {
+			c.negativeHits.Add(1)
+			return nil, ErrProductNotFound
+		}
+		if v, err := decode(body); err == nil {
+			c.hits.Add(1)
+			return v, nil
+		}
+	}
+	c.misses.Add(1)
+
+	v, err, shared := c.group.Do(key, func() (interface{}, error) {
+		v, err := load()
+		if err == ErrProductNotFound {
+			redisPkg.RDB.Set(redisPkg.Ctx, key, productCacheMissing, c.NegativeTTL)
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		if body, err := json.Marshal(v); err == nil {
+			redisPkg.RDB.Set(redisPkg.Ctx, key, body, c.ttl())
+		}
+		return v, nil
+	})
+	if shared {
+		c.coalesced.Add(1)
+	}
+	return v, err
+}
+
+func (c *ProductCache) ttl() time.Duration {
+	if c.Jitter <= 0 {
+		return c.TTL
+	}
+	return c.TTL + time.Duration(rand.Int63n(int64(c.Jitter)))
+}
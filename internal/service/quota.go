@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+// 每人限购：user:product:{uid}:{pid} 记录用户已购件数（旧版本写入的购买标记值为 1，语义兼容）。
+// 商品属于活动组时，还要满足组内跨商品的限制：累计件数 campaign:units:{gid}:{uid}、
+// 当日下单次数 campaign:orders:{gid}:{uid}:{yyyymmdd}，三者在同一个脚本里一起检查和预占。
+// 活动组要求预约时，同一脚本里先检查用户是否在预约集合 campaign:reserved:{gid} 中。
+const (
+	purchaseLimitsKey  = "product:limits"          // hash: productID -> 每人限购件数，缺省使用配置默认值
+	productGroupsKey   = "campaign:product_groups" // hash: productID -> 活动组ID
+	dailyOrdersKeepTTL = 48 * time.Hour            // 当日下单次数保留时长，跨过零点后自然过期
+)
+
+var (
+	ErrQuotaExceeded       = errors.New("purchase quota exceeded")
+	ErrGroupQuotaExceeded  = fmt.Errorf("%w: campaign group unit limit reached", ErrQuotaExceeded)
+	ErrDailyOrderLimit     = fmt.Errorf("%w: campaign group daily order limit reached", ErrQuotaExceeded)
+	ErrInvalidQuantity     = errors.New("quantity must be positive")
+	ErrReservationRequired = errors.New("campaign requires reservation before purchase")
+)
+
+// 预占限购额度，返回 -1 超出商品限购，-2 超出活动组件数，-3 超出活动组当日下单次数，-4 未预约
+// KEYS: 商品已购件数、商品限购hash、[活动组限制hash、活动组已购件数、活动组当日下单次数、活动组预约集合]
+// ARGV: 商品ID、件数、默认限购、当日计数过期秒数、用户ID
+var reserveQuotaScript = redis.NewScript(`
+local n = tonumber(ARGV[2])
+if #KEYS > 2 and redis.call('HGET', KEYS[3], 'require_reservation') == '1'
+	and redis.call('SISMEMBER', KEYS[6], ARGV[5]) == 0 then
+	return -4
+end
+local limit = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or ARGV[3])
+if tonumber(redis.call('GET', KEYS[1]) or '0') + n > limit then return -1 end
+if #KEYS > 2 then
+	local maxUnits = tonumber(redis.call('HGET', KEYS[3], 'max_units') or '0')
+	if maxUnits > 0 and tonumber(redis.call('GET', KEYS[4]) or '0') + n > maxUnits then return -2 end
+	local maxOrders = tonumber(redis.call('HGET', KEYS[3], 'max_orders_per_day') or '0')
+	if maxOrders > 0 and tonumber(redis.call('GET', KEYS[5]) or '0') + 1 > maxOrders then return -3 end
+	redis.call('INCRBY', KEYS[4], n)
+	redis.call('INCR', KEYS[5])
+	redis.call('EXPIRE', KEYS[5], ARGV[4])
+end
+return redis.call('INCRBY', KEYS[1], n)
+`)
+
+// 退回额度：KEYS 中的计数依次减去 ARGV[i]，归零后删除key
+var releaseQuotaScript = redis.NewScript(`
+for i = 1, #KEYS do
+	local v = redis.call('DECRBY', KEYS[i], ARGV[i])
+	if v <= 0 then redis.call('DEL', KEYS[i]) end
+end
+return 1
+`)
+
+func quotaKey(userID, productID uint) string {
+	return fmt.Sprintf("user:product:%d:%d", userID, productID)
+}
+
+func groupLimitsKey(groupID uint) string {
+	return fmt.Sprintf("campaign:limits:%d", groupID)
+}
+
+func groupUnitsKey(groupID, userID uint) string {
+	return fmt.Sprintf("campaign:units:%d:%d", groupID, userID)
+}
+
+func groupOrdersKey(groupID, userID uint, day string) string {
+	return fmt.Sprintf("campaign:orders:%d:%d:%s", groupID, userID, day)
+}
+
+func groupReservedKey(groupID uint) string {
+	return fmt.Sprintf("campaign:reserved:%d", groupID)
+}
+
+// 按服务器本地时间划分自然日
+func quotaDay(t time.Time) string {
+	return t.Format("20060102")
+}
+
+func defaultPurchaseLimit() int64 {
+	if n := viper.GetInt64("seckill.quota.default_limit"); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// 一次预占的额度，失败回滚时原样退回
+type quotaReservation struct {
+	userID    uint
+	productID uint
+	groupID   uint // 0 表示商品不属于活动组
+	day       string
+	n         int64
+}
+
+func reserveQuota(userID, productID uint, n int64) (*quotaReservation, error) {
+	field := strconv.FormatUint(uint64(productID), 10)
+	groupID, err := redisPkg.RDB.HGet(redisPkg.Ctx, productGroupsKey, field).Int()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	r := &quotaReservation{userID: userID, productID: productID, groupID: uint(groupID), day: quotaDay(time.Now()), n: n}
+	keys := []string{quotaKey(userID, productID), purchaseLimitsKey}
+	if r.groupID != 0 {
+		keys = append(keys, groupLimitsKey(r.groupID), groupUnitsKey(r.groupID, userID),
+			groupOrdersKey(r.groupID, userID, r.day), groupReservedKey(r.groupID))
+	}
+	res, err := reserveQuotaScript.Run(redisPkg.Ctx, redisPkg.RDB, keys,
+		field, n, defaultPurchaseLimit(), int(dailyOrdersKeepTTL.Seconds()), userID).Int64()
+	if err != nil {
+		return nil, err
+	}
+	switch res {
+	case -1:
+		return nil, ErrQuotaExceeded
+	case -2:
+		return nil, ErrGroupQuotaExceeded
+	case -3:
+		return nil, ErrDailyOrderLimit
+	case -4:
+		return nil, ErrReservationRequired
+	}
+	return r, nil
+}
+
+// 请求失败时退回全部预占（含当日下单次数）
+func (r *quotaReservation) release() error {
+	keys := []string{quotaKey(r.userID, r.productID)}
+	args := []interface{}{r.n}
+	if r.groupID != 0 {
+		keys = append(keys, groupUnitsKey(r.groupID, r.userID), groupOrdersKey(r.groupID, r.userID, r.day))
+		args = append(args, r.n, 1)
+	}
+	return releaseQuotaScript.Run(redisPkg.Ctx, redisPkg.RDB, keys, args...).Err()
+}
+
+// 订单取消后退回件数额度；当日下单次数不退，防止反复下单取消
+func releaseQuota(userID, productID uint, groupID *uint, n int64) error {
+	keys := []string{quotaKey(userID, productID)}
+	args := []interface{}{n}
+	if groupID != nil && *groupID != 0 {
+		keys = append(keys, groupUnitsKey(*groupID, userID))
+		args = append(args, n)
+	}
+	return releaseQuotaScript.Run(redisPkg.Ctx, redisPkg.RDB, keys, args...).Err()
+}
+
+// 登记商品的每人限购件数，limit<=0 表示使用默认值
+func setPurchaseLimit(pipe redis.Cmdable, productID uint, limit int) {
+	field := strconv.FormatUint(uint64(productID), 10)
+	if limit > 0 {
+		pipe.HSet(redisPkg.Ctx, purchaseLimitsKey, field, limit)
+	} else {
+		pipe.HDel(redisPkg.Ctx, purchaseLimitsKey, field)
+	}
+}
+
+// 登记商品所属活动组，groupID 为 nil 表示不属于任何活动组
+func setProductGroup(pipe redis.Cmdable, productID uint, groupID *uint) {
+	field := strconv.FormatUint(uint64(productID), 10)
+	if groupID != nil && *groupID != 0 {
+		pipe.HSet(redisPkg.Ctx, productGroupsKey, field, *groupID)
+	} else {
+		pipe.HDel(redisPkg.Ctx, productGroupsKey, field)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/lock"
+	redisPkg "seckill-system/internal/pkg/redis"
+)
+
+const (
+	rebuildBatchSize = 1000
+	rebuildLockName  = "redis:rebuild"
+	rebuildLockTTL   = 5 * time.Minute
+)
+
+type RebuildReport struct {
+	Markers  int         `json:"markers"`
+	Campaign int         `json:"campaign_counters"` // 活动组件数与当日下单次数计数
+	Stock    *SyncReport `json:"stock"`
+	Duration string      `json:"duration"`
+}
+
+// Redis 数据丢失（flush、无 AOF 的故障切换）后，从订单表重建限购计数，并以 MySQL 为准重置库存。
+// 在途消息对应的订单还没落库，其件数不会计入重建后的限购计数。
+func (s *ProductService) RebuildRedis() (*RebuildReport, error) {
+	// 多实例同时启动时只需要一个实例重建
+	l, err := lock.Acquire(rebuildLockName, rebuildLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	start := time.Now()
+	report := &RebuildReport{}
+
+	markers, err := s.RebuildPurchaseMarkers()
+	if err != nil {
+		return nil, err
+	}
+	report.Markers = markers
+
+	if report.Campaign, err = s.RebuildCampaignCounters(); err != nil {
+		return nil, err
+	}
+
+	if report.Stock, err = s.SyncStockToRedis(true); err != nil {
+		return nil, err
+	}
+
+	report.Duration = time.Since(start).String()
+	log.Printf("🔁 [Redis重建完成]: markers=%d, products=%d, cost=%s", report.Markers, report.Stock.Products, report.Duration)
+	return report, nil
+}
+
+// 按未取消订单汇总每个用户每个商品的已购件数，写回限购计数；
+// 直接 SET 汇总值，重复执行结果不变
+func (s *ProductService) RebuildPurchaseMarkers() (int, error) {
+	var (
+		lastUser, lastProduct uint
+		total                 int
+	)
+	for {
+		var rows []struct {
+			UserID    uint
+			ProductID uint
+			Bought    int64
+		}
+		err := s.DB.Model(&model.Order{}).
+			Select("user_id, product_id, SUM(quantity) AS bought").
+			Where("status <> ? AND (user_id > ? OR (user_id = ? AND product_id > ?))",
+				model.OrderCancelled, lastUser, lastUser, lastProduct).
+			Group("user_id, product_id").Order("user_id, product_id").
+			Limit(rebuildBatchSize).Scan(&rows).Error
+		if err != nil {
+			return total, err
+		}
+		if len(rows) == 0 {
+			return total, nil
+		}
+		last := rows[len(rows)-1]
+		lastUser, lastProduct = last.UserID, last.ProductID
+
+		pipe := redisPkg.RDB.Pipeline()
+		for _, r := range rows {
+			pipe.Set(redisPkg.Ctx, quotaKey(r.UserID, r.ProductID), r.Bought, 0)
+		}
+		if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+			return total, err
+		}
+		total += len(rows)
+	}
+}
+
+// 按订单重建活动组计数：累计件数统计未取消订单，当日下单次数统计今天的全部订单（取消不退次数）
+func (s *ProductService) RebuildCampaignCounters() (int, error) {
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := quotaDay(now)
+
+	var (
+		lastGroup, lastUser uint
+		total               int
+	)
+	for {
+		var rows []struct {
+			CampaignGroupID uint
+			UserID          uint
+			Units           int64
+			Today           int64
+		}
+		err := s.DB.Model(&model.Order{}).
+			Select("campaign_group_id, user_id, "+
+				"COALESCE(SUM(CASE WHEN status <> ? THEN quantity ELSE 0 END), 0) AS units, "+
+				"SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END) AS today",
+				model.OrderCancelled, dayStart).
+			Where("campaign_group_id IS NOT NULL AND (campaign_group_id > ? OR (campaign_group_id = ? AND user_id > ?))",
+				lastGroup, lastGroup, lastUser).
+			Group("campaign_group_id, user_id").Order("campaign_group_id, user_id").
+			Limit(rebuildBatchSize).Scan(&rows).Error
+		if err != nil {
+			return total, err
+		}
+		if len(rows) == 0 {
+			return total, nil
+		}
+		last := rows[len(rows)-1]
+		lastGroup, lastUser = last.CampaignGroupID, last.UserID
+
+		pipe := redisPkg.RDB.Pipeline()
+		for _, r := range rows {
+			if r.Units > 0 {
+				pipe.Set(redisPkg.Ctx, groupUnitsKey(r.CampaignGroupID, r.UserID), r.Units, 0)
+			}
+			if r.Today > 0 {
+				pipe.Set(redisPkg.Ctx, groupOrdersKey(r.CampaignGroupID, r.UserID, day), r.Today, dailyOrdersKeepTTL)
+			}
+		}
+		if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+			return total, err
+		}
+		total += len(rows)
+	}
+}
@@ -0,0 +1,436 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/lock"
+	"seckill-system/internal/pkg/metrics"
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+const (
+	reconcileLockName  = "stock:reconcile"
+	reconcileLockTTL   = 2 * time.Minute
+	reconcileBatchSize = 200
+	reconcileResample  = 200 * time.Millisecond // 发现差异后间隔一段时间复核，排除正在处理中的请求
+)
+
+// 单个商品的库存差异
+//
+//	RedisDrift = Redis剩余 + 实例租约 + 在途消息 - MySQL库存，>0 有超卖风险，<0 有库存卖不出去
+//	OrderDrift = MySQL库存 + 未取消订单件数 - 累计入库量，>0 已经超卖，<0 库存丢失
+type StockDrift struct {
+	ProductID  uint  `json:"product_id"`
+	RedisStock int64 `json:"redis_stock"`
+	Leased     int64 `json:"leased"`
+	InFlight   int64 `json:"in_flight"`
+	DBStock    int64 `json:"db_stock"`
+	Orders     int64 `json:"orders"`
+	TotalStock int64 `json:"total_stock"`
+	RedisDrift int64 `json:"redis_drift"`
+	OrderDrift int64 `json:"order_drift"`
+	Repaired   int64 `json:"repaired"`             // 修复时调整的 Redis 库存件数
+	NotSynced  bool  `json:"not_synced,omitempty"` // 有库存分片key缺失，等待回灌，不做修复
+	Lottery    bool  `json:"lottery,omitempty"`    // 抽签模式：开奖取走的库存不在租约和在途中，不做修复
+}
+
+// 修复：在脚本内按当前 Redis 值重新计算差值，与已确认差值同向时按较小者调整，
+// 任一分片key缺失时不动（返回 status=0），留给回灌的 MSETNX 整体补齐；
+// 商品处于抽签模式时不动（返回 status=2），开奖取走的库存直到投递下单前都不计入在途。
+// KEYS: 各分片库存key..., 租约hash, 在途计数, 抽签商品集合  ARGV: MySQL库存, 已确认差值, 商品ID
+// 返回 {status, 调整件数, 调整前Redis库存}
+var repairStockScript = redis.NewScript(`
+local n = #KEYS - 3
+if redis.call('SISMEMBER', KEYS[n + 3], ARGV[3]) == 1 then return {2, 0, 0} end
+local stocks = {}
+local redisStock = 0
+for i = 1, n do
+	local v = redis.call('GET', KEYS[i])
+	if not v then return {0, 0, 0} end
+	stocks[i] = tonumber(v)
+	redisStock = redisStock + stocks[i]
+end
+local leased = 0
+for _, v in ipairs(redis.call('HVALS', KEYS[n + 1])) do leased = leased + tonumber(v) end
+local inflight = tonumber(redis.call('GET', KEYS[n + 2]) or '0')
+local drift = redisStock + leased + inflight - tonumber(ARGV[1])
+local confirmed = tonumber(ARGV[2])
+if drift < 0 and confirmed < 0 then
+	local add = -math.max(drift, confirmed)
+	redis.call('INCRBY', KEYS[1], add)
+	return {1, add, redisStock}
+end
+if drift > 0 and confirmed > 0 then
+	local want = math.min(drift, confirmed)
+	local taken = 0
+	for i = 1, n do
+		if taken >= want then break end
+		local got = math.min(math.max(stocks[i], 0), want - taken)
+		if got > 0 then
+			redis.call('DECRBY', KEYS[i], got)
+			taken = taken + got
+		end
+	end
+	return {1, -taken, redisStock}
+end
+return {1, 0, redisStock}
+`)
+
+type ReconcileReport struct {
+	StartedAt time.Time    `json:"started_at"`
+	Duration  string       `json:"duration"`
+	Repair    bool         `json:"repair"`
+	Checked   int          `json:"checked"`
+	Drifts    []StockDrift `json:"drifts"`
+}
+
+// 定期核对 Redis 库存、MySQL 库存与订单数
+type StockReconciler struct {
+	DB         *gorm.DB
+	SoldOut    *SoldOutCache
+	Enabled    bool
+	Interval   time.Duration
+	Cron       string // 设置后按 cron 执行，优先于 Interval
+	AutoRepair bool
+
+	mu   sync.Mutex
+	last *ReconcileReport
+}
+
+func NewStockReconciler(db *gorm.DB, soldOut *SoldOutCache) *StockReconciler {
+	r := &StockReconciler{
+		DB:         db,
+		SoldOut:    soldOut,
+		Enabled:    viper.GetBool("seckill.reconcile.enabled"),
+		Interval:   viper.GetDuration("seckill.reconcile.interval"),
+		Cron:       viper.GetString("seckill.reconcile.cron"),
+		AutoRepair: viper.GetBool("seckill.reconcile.repair"),
+	}
+	if r.Interval <= 0 {
+		r.Interval = time.Minute
+	}
+	return r
+}
+
+// 定时任务入口：手动修复正在进行时跳过本轮
+func (r *StockReconciler) RunScheduled(ctx context.Context) error {
+	_, err := r.Run(ctx, r.AutoRepair)
+	if err == lock.ErrNotAcquired {
+		return nil
+	}
+	return err
+}
+
+// 立即对账，repair=true 时在锁内修复 Redis 库存
+func (r *StockReconciler) Run(ctx context.Context, repair bool) (*ReconcileReport, error) {
+	if !repair {
+		return r.run(ctx, nil)
+	}
+	l, err := lock.Acquire(reconcileLockName, reconcileLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return r.run(ctx, l)
+}
+
+func (r *StockReconciler) Last() *ReconcileReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// repairLock 不为空时修复差异，每次修复前校验栅栏令牌
+func (r *StockReconciler) run(ctx context.Context, repairLock *lock.Lock) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		StartedAt: time.Now(),
+		Repair:    repairLock != nil,
+		Drifts:    []StockDrift{},
+	}
+	metrics.Inc("reconcile_runs")
+
+	var lastID uint
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var products []model.Product
+		err := r.DB.Select("id", "stock_shards").
+			Where("id > ?", lastID).Order("id").Limit(reconcileBatchSize).
+			Find(&products).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(products) == 0 {
+			break
+		}
+		lastID = products[len(products)-1].ID
+		report.Checked += len(products)
+
+		drifts, err := r.measure(products)
+		if err != nil {
+			return nil, err
+		}
+		if len(drifts) == 0 {
+			continue
+		}
+
+		// 复核：两次采样都有差异才算，取差值较小的一次
+		time.Sleep(reconcileResample)
+		drifted := make([]model.Product, 0, len(drifts))
+		for _, p := range products {
+			if _, ok := drifts[p.ID]; ok {
+				drifted = append(drifted, p)
+			}
+		}
+		again, err := r.measure(drifted)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range drifted {
+			d := confirmDrift(drifts[p.ID], again[p.ID])
+			if d == nil {
+				continue
+			}
+			r.record(d)
+			if repairLock != nil && d.RedisDrift != 0 {
+				// 锁过期被其他实例拿走后停止修复，避免两边重复调整
+				if valid, err := repairLock.Valid(); err != nil || !valid {
+					return nil, fmt.Errorf("reconcile lock lost (fence=%d)", repairLock.Fence)
+				}
+				r.repair(d, p.StockShards)
+			}
+			report.Drifts = append(report.Drifts, *d)
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt).String()
+	metrics.Set("reconcile_drifted_products", int64(len(report.Drifts)))
+
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+	return report, nil
+}
+
+// 采样一批商品，只返回有差异的
+func (r *StockReconciler) measure(products []model.Product) (map[uint]*StockDrift, error) {
+	ids := make([]uint, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+
+	// Redis 与 MySQL 无法同时读取，处理中的消息会造成瞬时差异，由复核过滤
+	pipe := redisPkg.RDB.Pipeline()
+	stockCmds := make([]*redis.SliceCmd, len(products))
+	existsCmds := make([]*redis.IntCmd, len(products))
+	leaseCmds := make([]*redis.StringSliceCmd, len(products))
+	inflightCmds := make([]*redis.StringCmd, len(products))
+	lotteryCmds := make([]*redis.BoolCmd, len(products))
+	for i, p := range products {
+		stockCmds[i] = pipe.MGet(redisPkg.Ctx, stockKeys(p.ID, p.StockShards)...)
+		existsCmds[i] = pipe.Exists(redisPkg.Ctx, stockKeys(p.ID, p.StockShards)...)
+		leaseCmds[i] = pipe.HVals(redisPkg.Ctx, leaseKey(p.ID))
+		inflightCmds[i] = pipe.Get(redisPkg.Ctx, inflightKey(p.ID))
+		lotteryCmds[i] = pipe.SIsMember(redisPkg.Ctx, lotteryProductsKey, p.ID)
+	}
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var rows []model.Product
+	if err := r.DB.Select("id", "stock", "total_stock").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	dbStock := make(map[uint]model.Product, len(rows))
+	for _, row := range rows {
+		dbStock[row.ID] = row
+	}
+
+	var counts []struct {
+		ProductID uint
+		Orders    int64
+	}
+	err := r.DB.Model(&model.Order{}).
+		Select("product_id, SUM(quantity) AS orders").
+		// 规格订单扣的是规格库存，组合商品订单扣的是组件库存，都不计入商品本身
+		Where("product_id IN ? AND status <> ? AND sku_id IS NULL AND bundle = ?", ids, model.OrderCancelled, false).
+		Group("product_id").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	orders := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		orders[c.ProductID] = c.Orders
+	}
+	// 作为组合商品组件售出的件数
+	counts = counts[:0]
+	err = r.DB.Model(&model.OrderItem{}).
+		Select("order_items.product_id, SUM(order_items.quantity) AS orders").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.product_id IN ? AND orders.status <> ?", ids, model.OrderCancelled).
+		Group("order_items.product_id").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		orders[c.ProductID] += c.Orders
+	}
+
+	drifts := make(map[uint]*StockDrift)
+	for i, p := range products {
+		row, ok := dbStock[p.ID]
+		if !ok {
+			continue
+		}
+		d := &StockDrift{
+			ProductID:  p.ID,
+			RedisStock: sumValues(stockCmds[i].Val()),
+			Leased:     sumStrings(leaseCmds[i].Val()),
+			InFlight:   parseInt64(inflightCmds[i].Val()),
+			DBStock:    int64(row.Stock),
+			Orders:     orders[p.ID],
+			TotalStock: int64(row.TotalStock),
+			NotSynced:  existsCmds[i].Val() < int64(len(stockKeys(p.ID, p.StockShards))),
+			Lottery:    lotteryCmds[i].Val(),
+		}
+		d.RedisDrift = d.RedisStock + d.Leased + d.InFlight - d.DBStock
+		d.OrderDrift = d.DBStock + d.Orders - d.TotalStock
+		if d.RedisDrift != 0 || d.OrderDrift != 0 {
+			drifts[p.ID] = d
+		}
+	}
+	return drifts, nil
+}
+
+// 两次采样方向一致才认定为差异，取绝对值较小者
+func confirmDrift(first, second *StockDrift) *StockDrift {
+	if first == nil || second == nil {
+		return nil
+	}
+	d := *second
+	d.RedisDrift = smallerSameSign(first.RedisDrift, second.RedisDrift)
+	d.OrderDrift = smallerSameSign(first.OrderDrift, second.OrderDrift)
+	if d.RedisDrift == 0 && d.OrderDrift == 0 {
+		return nil
+	}
+	return &d
+}
+
+func smallerSameSign(a, b int64) int64 {
+	if a == 0 || b == 0 || (a > 0) != (b > 0) {
+		return 0
+	}
+	if a > 0 {
+		return min(a, b)
+	}
+	return max(a, b)
+}
+
+func (r *StockReconciler) record(d *StockDrift) {
+	log.Printf("⚠️ [库存差异]: productID=%d, redis=%d, leased=%d, inflight=%d, db=%d, orders=%d, total=%d, redisDrift=%d, orderDrift=%d",
+		d.ProductID, d.RedisStock, d.Leased, d.InFlight, d.DBStock, d.Orders, d.TotalStock, d.RedisDrift, d.OrderDrift)
+
+	if d.RedisDrift > 0 {
+		metrics.Add("reconcile_redis_oversell_units", d.RedisDrift)
+	} else if d.RedisDrift < 0 {
+		metrics.Add("reconcile_redis_undersell_units", -d.RedisDrift)
+	}
+	if d.OrderDrift > 0 {
+		metrics.Add("reconcile_order_oversell_units", d.OrderDrift)
+	} else if d.OrderDrift < 0 {
+		metrics.Add("reconcile_order_lost_units", -d.OrderDrift)
+	}
+}
+
+// 以 MySQL 为准按差值增减 Redis 库存（不覆盖，避免吞掉并发的售卖）
+func (r *StockReconciler) repair(d *StockDrift, shards int) {
+	if d.NotSynced {
+		log.Printf("⚠️ [库存未回灌，跳过修复]: productID=%d", d.ProductID)
+		return
+	}
+	if d.Lottery {
+		log.Printf("⚠️ [抽签商品，跳过修复]: productID=%d", d.ProductID)
+		return
+	}
+	keys := append(stockKeys(d.ProductID, shards), leaseKey(d.ProductID), inflightKey(d.ProductID), lotteryProductsKey)
+	res, err := repairStockScript.Run(redisPkg.Ctx, redisPkg.RDB, keys, d.DBStock, d.RedisDrift, d.ProductID).Int64Slice()
+	if err != nil {
+		log.Printf("⚠️ [库存修复失败]: productID=%d, err=%v", d.ProductID, err)
+		return
+	}
+	switch res[0] {
+	case 0:
+		d.NotSynced = true
+		log.Printf("⚠️ [库存未回灌，跳过修复]: productID=%d", d.ProductID)
+		return
+	case 2:
+		d.Lottery = true
+		log.Printf("⚠️ [抽签商品，跳过修复]: productID=%d", d.ProductID)
+		return
+	}
+	d.Repaired = res[1]
+	before := res[2]
+	if d.Repaired == 0 {
+		return
+	}
+	if d.Repaired > 0 && r.SoldOut != nil {
+		r.SoldOut.Clear(d.ProductID)
+	}
+	metrics.Add("reconcile_repaired_units", abs64(d.Repaired))
+	log.Printf("🔧 [库存已修复]: productID=%d, adjust=%d", d.ProductID, d.Repaired)
+
+	// 只改动 Redis，不写库存流水（流水记录的是 MySQL 库存）
+	err = r.DB.Create(&model.StockRepair{
+		ProductID: d.ProductID,
+		Adjust:    d.Repaired,
+		Before:    before,
+		After:     before + d.Repaired,
+		DBStock:   d.DBStock,
+		Drift:     d.RedisDrift,
+		Actor:     "reconciler",
+	}).Error
+	if err != nil {
+		log.Printf("⚠️ [库存修复记录写入失败]: productID=%d, err=%v", d.ProductID, err)
+	}
+}
+
+func sumValues(vals []interface{}) int64 {
+	var total int64
+	for _, v := range vals {
+		if s, ok := v.(string); ok {
+			total += parseInt64(s)
+		}
+	}
+	return total
+}
+
+func sumStrings(vals []string) int64 {
+	var total int64
+	for _, s := range vals {
+		total += parseInt64(s)
+	}
+	return total
+}
+
+func parseInt64(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"seckill-system/internal/model"
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 活动预约：MySQL 记录为准，Redis 集合 campaign:reserved:{gid} 供秒杀脚本原子检查。
+// 预约总是写入集合，活动组之后再开启 RequireReservation 也能立即生效
+const reservationBatchSize = 1000
+
+var (
+	ErrReservationClosed = errors.New("campaign reservations are closed")
+	ErrNotReserved       = errors.New("not reserved for this campaign")
+)
+
+type ReservationView struct {
+	CampaignGroupID uint      `json:"campaign_group_id"`
+	UserID          uint      `json:"user_id"`
+	ReservedAt      time.Time `json:"reserved_at"`
+}
+
+type CampaignStats struct {
+	CampaignGroupID    uint  `json:"campaign_group_id"`
+	RequireReservation bool  `json:"require_reservation"`
+	Reservations       int64 `json:"reservations"`
+	Products           int64 `json:"products"`
+	Orders             int64 `json:"orders"` // 未取消的订单
+	Units              int64 `json:"units"`
+	Buyers             int64 `json:"buyers"`
+}
+
+// 预约活动，重复预约返回原记录
+func (s *CampaignService) Reserve(groupID, userID uint) (*ReservationView, error) {
+	group, err := s.Get(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group.ReserveUntil != nil && time.Now().After(*group.ReserveUntil) {
+		return nil, ErrReservationClosed
+	}
+
+	r := model.CampaignReservation{CampaignGroupID: groupID, UserID: userID}
+	if err := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&r).Error; err != nil {
+		return nil, err
+	}
+	if err := redisPkg.RDB.SAdd(redisPkg.Ctx, groupReservedKey(groupID), userID).Err(); err != nil {
+		return nil, err
+	}
+	return s.Reservation(groupID, userID)
+}
+
+func (s *CampaignService) Reservation(groupID, userID uint) (*ReservationView, error) {
+	var r model.CampaignReservation
+	err := s.DB.Where("campaign_group_id = ? AND user_id = ?", groupID, userID).First(&r).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotReserved
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ReservationView{CampaignGroupID: groupID, UserID: userID, ReservedAt: r.CreatedAt}, nil
+}
+
+// 取消预约，已下的订单不受影响；预约截止后不能再预约，也就不允许取消。
+// 先移出 Redis 集合，删除记录失败时由回灌补回
+func (s *CampaignService) CancelReservation(groupID, userID uint) error {
+	group, err := s.Get(groupID)
+	if err != nil {
+		return err
+	}
+	if group.ReserveUntil != nil && time.Now().After(*group.ReserveUntil) {
+		return ErrReservationClosed
+	}
+	if _, err := s.Reservation(groupID, userID); err != nil {
+		return err
+	}
+	if err := redisPkg.RDB.SRem(redisPkg.Ctx, groupReservedKey(groupID), userID).Err(); err != nil {
+		return err
+	}
+	return s.DB.Where("campaign_group_id = ? AND user_id = ?", groupID, userID).
+		Delete(&model.CampaignReservation{}).Error
+}
+
+func (s *CampaignService) Stats(groupID uint) (*CampaignStats, error) {
+	group, err := s.Get(groupID)
+	if err != nil {
+		return nil, err
+	}
+	stats := &CampaignStats{CampaignGroupID: groupID, RequireReservation: group.RequireReservation}
+	err = s.DB.Model(&model.CampaignReservation{}).Where("campaign_group_id = ?", groupID).
+		Count(&stats.Reservations).Error
+	if err != nil {
+		return nil, err
+	}
+	err = s.DB.Model(&model.Product{}).Where("campaign_group_id = ?", groupID).Count(&stats.Products).Error
+	if err != nil {
+		return nil, err
+	}
+	err = s.DB.Model(&model.Order{}).
+		Select("COUNT(*) AS orders, COALESCE(SUM(quantity), 0) AS units, COUNT(DISTINCT user_id) AS buyers").
+		Where("campaign_group_id = ? AND status <> ?", groupID, model.OrderCancelled).
+		Scan(stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// 导出预约用户为 CSV（user_id, username, reserved_at），按预约ID分页读取
+func (s *CampaignService) ExportReservations(groupID uint, w io.Writer) error {
+	if _, err := s.Get(groupID); err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"user_id", "username", "reserved_at"}); err != nil {
+		return err
+	}
+
+	type row struct {
+		ID        uint
+		UserID    uint
+		Username  string
+		CreatedAt time.Time
+	}
+	var lastID uint
+	for {
+		var rows []row
+		err := s.DB.Table("campaign_reservations AS r").
+			Select("r.id, r.user_id, u.username, r.created_at").
+			Joins("LEFT JOIN users AS u ON u.id = r.user_id").
+			Where("r.campaign_group_id = ? AND r.id > ?", groupID, lastID).
+			Order("r.id").Limit(reservationBatchSize).
+			Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			err := out.Write([]string{
+				strconv.FormatUint(uint64(r.UserID), 10),
+				r.Username,
+				r.CreatedAt.Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return err
+		}
+		if len(rows) < reservationBatchSize {
+			return nil
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+}
+
+// 回灌预约集合：只补充，不删除集合中多出的用户（取消预约时已同步移除）
+func syncReservations(db *gorm.DB) error {
+	var lastID uint
+	for {
+		var rows []model.CampaignReservation
+		err := db.Select("id", "campaign_group_id", "user_id").
+			Where("id > ?", lastID).Order("id").Limit(reservationBatchSize).
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		pipe := redisPkg.RDB.Pipeline()
+		for _, r := range rows {
+			pipe.SAdd(redisPkg.Ctx, groupReservedKey(r.CampaignGroupID), r.UserID)
+		}
+		if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+			return err
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+}
@@ -1,8 +1,11 @@
 package service
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"seckill-system/internal/model"
 	mqPkg "seckill-system/internal/pkg/mq"
 	redisPkg "seckill-system/internal/pkg/redis"
@@ -11,7 +14,10 @@ import (
 	"github.com/streadway/amqp"
 )
 
-type SeckillService struct{}
+type SeckillService struct {
+	SoldOut *SoldOutCache // 本地售罄标记
+	Leaser  *StockLeaser  // 本地预分配库存
+}
 
 // 限流检查
 func (s *SeckillService) RateLimitCheck(userID uint, productID uint) (bool, int, error) {
@@ -40,44 +46,112 @@ func (s *SeckillService) RateLimitCheck(userID uint, productID uint) (bool, int,
 	return true, int(remaining), nil
 }
 
-func (s *SeckillService) StartSeckill(productID uint, userID uint) error {
-	//1.检查用户是否已经购买
-	orderKey := fmt.Sprintf("user:product:%d:%d", userID, productID)
-	if redisPkg.RDB.Exists(redisPkg.Ctx, orderKey).Val() > 0 {
-		return fmt.Errorf("already purchased")
+// skuID 为 0 表示按商品扣库存；有规格的商品必须指定规格，库存只扣规格自己的key；
+// 组合商品一次扣减全部组件
+func (s *SeckillService) StartSeckill(productID uint, skuID uint, userID uint, quantity int) error {
+	if quantity < 1 {
+		return ErrInvalidQuantity
+	}
+	n := int64(quantity)
+
+	//0.本地售罄标记命中，直接返回，不访问redis（本地租约还有货时不受影响）
+	leased := s.Leaser != nil && s.Leaser.Has(productID)
+	if skuID == 0 && !leased && s.SoldOut != nil && s.SoldOut.IsSoldOut(productID) {
+		return ErrOutOfStock
+	}
+
+	//0.1 校验商品存在且在售，未知ID不触碰任何库存key
+	if err := checkProduct(productID); err != nil {
+		return err
+	}
+	if err := checkSKU(productID, skuID); err != nil {
+		return err
+	}
+	hold := &stockHold{productID: productID, skuID: skuID}
+	if skuID == 0 {
+		components, err := bundles.Components(productID)
+		if err != nil {
+			return err
+		}
+		hold.components = components
 	}
 
-	//2.redis 原子扣减
-	key := fmt.Sprintf("stock:%d", productID)
-	stock, err := redisPkg.RDB.Decr(redisPkg.Ctx, key).Result()
+	//1.预占限购额度（商品已购件数、活动组件数与当日下单次数），同一用户的并发请求也不会超限
+	quota, err := reserveQuota(userID, productID, n)
 	if err != nil {
 		return err
 	}
 
-	//3.库存检查
-	if stock < 0 {
-		// 库存不足，回滚
-		redisPkg.RDB.Incr(redisPkg.Ctx, key)
-		return fmt.Errorf("out of stock")
+	//2.优先从本地租约售卖
+	sold := false
+	if skuID != 0 {
+		//2.1 规格库存不分片、不租约，直接原子扣减
+		if err := deductSKUStock(skuID, n); err != nil {
+			s.releaseQuota(quota)
+			return err
+		}
+		sold = true
+	} else if hold.components != nil {
+		//2.2 组合商品：全部组件在一个脚本里扣减，任一不足则都不扣
+		if err := deductBundleStock(hold.components, n); err != nil {
+			s.releaseQuota(quota)
+			return err
+		}
+		sold = true
+	} else if s.Leaser != nil {
+		if sold, err = s.Leaser.Sell(productID, n); err != nil {
+			s.releaseQuota(quota)
+			return err
+		}
 	}
 
-	//4.记录购买信息
-	redisPkg.RDB.Set(redisPkg.Ctx, orderKey, 1, 0)
+	//3.redis 原子扣减（分片商品先扣用户所在分片，不够再找兄弟分片）
+	if !sold {
+		hold.shard, err = deductStock(productID, userID, n)
+		if err != nil {
+			s.releaseQuota(quota)
+			if err == ErrOutOfStock && n == 1 && s.SoldOut != nil {
+				// 连一件都扣不到才算售罄，多件购买失败时可能还有零散库存
+				s.SoldOut.MarkSoldOut(productID)
+			}
+			return err
+		}
+	}
 
-	//5.发送消息到rabbitmq(异步处理)
+	//4.发送消息到rabbitmq(异步处理)
 	message := model.SeckillMessage{
 		UserID:    userID,
 		ProductID: productID,
+		SKUID:     skuID,
+		Bundle:    hold.components != nil,
+		Quantity:  quantity,
+		RequestID: newRequestID(),
 	}
 
 	body, err := json.Marshal(message)
 	if err != nil {
-		redisPkg.RDB.Incr(redisPkg.Ctx, key)
-		redisPkg.RDB.Del(redisPkg.Ctx, orderKey)
+		s.restoreStock(hold, n)
+		s.releaseQuota(quota)
+		return err
+	}
+
+	// 在途件数，对账时用来解释 Redis 与 MySQL 的差值
+	hold.inflight(n)
+
+	if err := publishOrder(body); err != nil {
+		// 发送消息失败，回滚库存和限购额度
+		hold.inflight(-n)
+		s.restoreStock(hold, n)
+		s.releaseQuota(quota)
 		return err
 	}
 
-	err = mqPkg.Channel.Publish(
+	return nil //抢购成功
+}
+
+// 投递下单消息，由 OrderConsumer 异步落库
+func publishOrder(body []byte) error {
+	return mqPkg.Channel.Publish(
 		"",              // exchange
 		mqPkg.QueueName, // routing key
 		false,           // mandatory
@@ -87,13 +161,61 @@ func (s *SeckillService) StartSeckill(productID uint, userID uint) error {
 			Body:        body,
 		},
 	)
+}
 
-	if err != nil {
-		// 发送消息失败，回滚库存和购买记录
-		redisPkg.RDB.Incr(redisPkg.Ctx, key)
-		redisPkg.RDB.Del(redisPkg.Ctx, orderKey)
-		return err
+// 一次抢购扣下的库存：商品分片、规格或组合商品的各组件
+type stockHold struct {
+	productID  uint
+	skuID      uint
+	shard      int
+	components []bundleComponent
+}
+
+// 增减在途件数，与库存扣在同一处
+func (h *stockHold) inflight(n int64) {
+	switch {
+	case h.skuID != 0:
+		redisPkg.RDB.IncrBy(redisPkg.Ctx, skuInflightKey(h.skuID), n)
+	case h.components != nil:
+		bundleInflight(h.components, n)
+	default:
+		redisPkg.RDB.IncrBy(redisPkg.Ctx, inflightKey(h.productID), n)
 	}
+}
 
-	return nil //抢购成功
+// 按原路归还库存
+func (h *stockHold) restore(n int64) {
+	switch {
+	case h.skuID != 0:
+		if err := restoreSKUStock(h.skuID, n); err != nil {
+			log.Printf("⚠️ [规格库存回滚失败]: skuID=%d, err=%v", h.skuID, err)
+		}
+	case h.components != nil:
+		restoreBundleStock(h.components, n)
+	default:
+		if err := restoreStock(h.productID, h.shard, n); err != nil {
+			log.Printf("⚠️ [库存回滚失败]: productID=%d, shard=%d, err=%v", h.productID, h.shard, err)
+		}
+	}
+}
+
+// 归还库存，并清除售罄标记
+func (s *SeckillService) restoreStock(h *stockHold, n int64) {
+	h.restore(n)
+	if h.skuID == 0 && h.components == nil && s.SoldOut != nil {
+		s.SoldOut.Clear(h.productID)
+	}
+}
+
+func (s *SeckillService) releaseQuota(quota *quotaReservation) {
+	if err := quota.release(); err != nil {
+		log.Printf("⚠️ [限购额度回滚失败]: userID=%d, productID=%d, err=%v", quota.userID, quota.productID, err)
+	}
+}
+
+// 抢购请求ID，随消息传给消费者用于去重
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
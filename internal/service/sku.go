@@ -0,0 +1,315 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/money"
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 规格库存：stock:sku:{skuID}，不分片、不参与实例租约；
+// 有规格的商品登记在 product:sku_products 中，秒杀时必须指定规格
+const (
+	skuProductsKey     = "sku:product"          // hash: skuID -> productID，校验规格属于该商品
+	productsWithSKUKey = "product:sku_products" // 有规格的商品ID集合
+)
+
+var (
+	ErrSKUNotFound     = errors.New("sku not found")
+	ErrSKURequired     = errors.New("sku_id is required for this product")
+	ErrProductHasStock = errors.New("product has product-level stock, set it to 0 before adding skus")
+)
+
+func skuStockKey(skuID uint) string {
+	return fmt.Sprintf("stock:sku:%d", skuID)
+}
+
+func skuInflightKey(skuID uint) string {
+	return fmt.Sprintf("stock:inflight:sku:%d", skuID)
+}
+
+// 校验规格：有规格的商品必须指定规格，且规格属于该商品
+func checkSKU(productID, skuID uint) error {
+	pipe := redisPkg.RDB.Pipeline()
+	hasSKU := pipe.SIsMember(redisPkg.Ctx, productsWithSKUKey, productID)
+	owner := pipe.HGet(redisPkg.Ctx, skuProductsKey, strconv.FormatUint(uint64(skuID), 10))
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil && err != redis.Nil {
+		return err
+	}
+	if skuID == 0 {
+		if hasSKU.Val() {
+			return ErrSKURequired
+		}
+		return nil
+	}
+	if id, _ := owner.Uint64(); uint(id) != productID {
+		return ErrSKUNotFound
+	}
+	return nil
+}
+
+// 登记规格，overwrite=false 时库存key已存在则不覆盖
+func registerSKU(pipe redis.Cmdable, sku *model.SKU, stock int, overwrite bool) {
+	registerSKULayout(pipe, sku)
+	if overwrite {
+		pipe.Set(redisPkg.Ctx, skuStockKey(sku.ID), stock, 0)
+	} else {
+		pipe.SetNX(redisPkg.Ctx, skuStockKey(sku.ID), stock, 0)
+	}
+}
+
+// 登记规格所属商品
+func registerSKULayout(pipe redis.Cmdable, sku *model.SKU) {
+	pipe.HSet(redisPkg.Ctx, skuProductsKey, strconv.FormatUint(uint64(sku.ID), 10), sku.ProductID)
+	pipe.SAdd(redisPkg.Ctx, productsWithSKUKey, sku.ProductID)
+}
+
+func deductSKUStock(skuID uint, n int64) error {
+	left, err := decrStockScript.Run(redisPkg.Ctx, redisPkg.RDB, []string{skuStockKey(skuID)}, n).Int64()
+	if err != nil {
+		return err
+	}
+	if left < 0 {
+		return ErrOutOfStock
+	}
+	return nil
+}
+
+func restoreSKUStock(skuID uint, n int64) error {
+	return redisPkg.RDB.IncrBy(redisPkg.Ctx, skuStockKey(skuID), n).Err()
+}
+
+// 按差值增减规格库存，减少时可售库存不足返回 ErrOutOfStock
+func adjustSKUStock(skuID uint, delta int64) error {
+	args := []interface{}{delta}
+	if delta > 0 {
+		args = append(args, delta)
+	}
+	res, err := adjustStockScript.Run(redisPkg.Ctx, redisPkg.RDB, []string{skuStockKey(skuID)}, args...).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case -1:
+		return ErrStockNotSynced
+	case 0:
+		return ErrOutOfStock
+	}
+	return nil
+}
+
+// 删除商品下全部规格的库存key
+func removeSKUStock(productID uint, skuIDs []uint) error {
+	pipe := redisPkg.RDB.TxPipeline()
+	pipe.SRem(redisPkg.Ctx, productsWithSKUKey, productID)
+	for _, id := range skuIDs {
+		pipe.Del(redisPkg.Ctx, skuStockKey(id))
+		pipe.HDel(redisPkg.Ctx, skuProductsKey, strconv.FormatUint(uint64(id), 10))
+	}
+	_, err := pipe.Exec(redisPkg.Ctx)
+	return err
+}
+
+type SKUService struct {
+	DB    *gorm.DB
+	Cache *ProductCache
+}
+
+type SKUParams struct {
+	Name         string
+	Stock        int
+	Price        money.Money
+	SeckillPrice money.Money // 金额为 0 表示不设秒杀价
+}
+
+// 对外展示的规格，Stock 为 Redis 中的实时可售库存
+type SKUItem struct {
+	ID           uint        `json:"id"`
+	ProductID    uint        `json:"product_id"`
+	Name         string      `json:"name"`
+	Price        money.Money `json:"price"`
+	SeckillPrice money.Money `json:"seckill_price"`
+	Stock        int64       `json:"stock"`
+}
+
+// 新增规格：商品自身库存需为 0，规格库存与初始流水在同一事务写入
+func (s *SKUService) Create(productID uint, params SKUParams, actor string) (*model.SKU, error) {
+	if err := checkSeckillPrice(params.Price, params.SeckillPrice); err != nil {
+		return nil, err
+	}
+	sku := &model.SKU{
+		ProductID:    productID,
+		Name:         params.Name,
+		Stock:        params.Stock,
+		TotalStock:   params.Stock,
+		Price:        params.Price,
+		SeckillPrice: params.SeckillPrice,
+	}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var product model.Product
+		err := tx.Select("id", "stock").First(&product, productID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if product.Stock != 0 {
+			return ErrProductHasStock
+		}
+		var count int64
+		err = tx.Model(&model.BundleItem{}).Where("bundle_id = ? OR product_id = ?", productID, productID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: bundles and bundle components cannot have skus", ErrInvalidBundle)
+		}
+		if err := tx.Create(sku).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.InventoryLedger{
+			ProductID: productID,
+			SKUID:     &sku.ID,
+			Type:      model.LedgerInit,
+			Quantity:  sku.Stock,
+			Before:    0,
+			After:     sku.Stock,
+			Actor:     actor,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := redisPkg.RDB.TxPipeline()
+	registerSKU(pipe, sku, sku.Stock, false)
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+		return nil, err
+	}
+	s.Cache.Invalidate()
+	return sku, nil
+}
+
+// 商品的全部规格及实时库存
+func (s *SKUService) List(productID uint) ([]SKUItem, error) {
+	if err := s.DB.Select("id").First(&model.Product{}, productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	var skus []model.SKU
+	if err := s.DB.Where("product_id = ?", productID).Order("id").Find(&skus).Error; err != nil {
+		return nil, err
+	}
+	live, err := liveSKUStock(skus)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]SKUItem, 0, len(skus))
+	for _, sku := range skus {
+		stock, ok := live[sku.ID]
+		if !ok {
+			stock = int64(sku.Stock)
+		}
+		items = append(items, SKUItem{
+			ID:           sku.ID,
+			ProductID:    sku.ProductID,
+			Name:         sku.Name,
+			Price:        sku.Price,
+			SeckillPrice: sku.SeckillPrice,
+			Stock:        stock,
+		})
+	}
+	return items, nil
+}
+
+// 批量读取规格的实时库存，Redis 未同步的规格不返回
+func liveSKUStock(skus []model.SKU) (map[uint]int64, error) {
+	live := make(map[uint]int64, len(skus))
+	if len(skus) == 0 {
+		return live, nil
+	}
+	keys := make([]string, len(skus))
+	for i, sku := range skus {
+		keys[i] = skuStockKey(sku.ID)
+	}
+	vals, err := redisPkg.RDB.MGet(redisPkg.Ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vals {
+		if str, ok := v.(string); ok {
+			live[skus[i].ID] = parseInt64(str)
+		}
+	}
+	return live, nil
+}
+
+// 有规格的商品，把各规格实时库存之和计入商品库存
+func (s *ProductService) overlaySKUStock(products []model.Product, live map[uint]int64) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	var skus []model.SKU
+	if err := s.DB.Select("id", "product_id", "stock").Where("product_id IN ?", ids).Find(&skus).Error; err != nil {
+		return err
+	}
+	skuLive, err := liveSKUStock(skus)
+	if err != nil {
+		return err
+	}
+	for _, sku := range skus {
+		stock, ok := skuLive[sku.ID]
+		if !ok {
+			stock = int64(sku.Stock)
+		}
+		live[sku.ProductID] += stock
+	}
+	return nil
+}
+
+// 回灌规格库存：权威模式在脚本内写入 DB库存 - 在途件数，否则只补齐缺失的key
+func (s *ProductService) syncSKUs(authoritative bool, report *SyncReport) error {
+	var lastID uint
+	for {
+		var skus []model.SKU
+		err := s.DB.Select("id", "product_id", "stock").
+			Where("id > ?", lastID).Order("id").Limit(syncBatchSize).
+			Find(&skus).Error
+		if err != nil {
+			return err
+		}
+		if len(skus) == 0 {
+			return nil
+		}
+		lastID = skus[len(skus)-1].ID
+		report.SKUs += len(skus)
+
+		pipe := redisPkg.RDB.Pipeline()
+		for i := range skus {
+			if !authoritative {
+				registerSKU(pipe, &skus[i], skus[i].Stock, false)
+				continue
+			}
+			// 规格库存不分片也没有租约，复用商品的权威覆盖脚本
+			registerSKULayout(pipe, &skus[i])
+			keys := []string{skuStockKey(skus[i].ID), skuInflightKey(skus[i].ID)}
+			authoritativeStockScript.Eval(redisPkg.Ctx, pipe, keys, skus[i].Stock, 1)
+		}
+		if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	redisPkg "seckill-system/internal/pkg/redis"
+	"seckill-system/internal/utils"
+)
+
+const (
+	soldOutChannel = "seckill:soldout" // 售罄标记广播频道
+	soldOutTTL     = 30 * time.Second  // 本地标记有效期，防止错过清除广播后一直拒绝
+)
+
+type soldOutEvent struct {
+	ProductID uint   `json:"product_id"`
+	SoldOut   bool   `json:"sold_out"`
+	Source    string `json:"source"`
+}
+
+// 进程内售罄商品集合，命中后秒杀请求无需访问 Redis
+type SoldOutCache struct {
+	mu  sync.RWMutex
+	ids map[uint]time.Time // productID -> 标记时间
+}
+
+func NewSoldOutCache() *SoldOutCache {
+	return &SoldOutCache{ids: make(map[uint]time.Time)}
+}
+
+func (c *SoldOutCache) IsSoldOut(productID uint) bool {
+	c.mu.RLock()
+	markedAt, ok := c.ids[productID]
+	c.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if time.Since(markedAt) < soldOutTTL {
+		return true
+	}
+	// 过期标记读到时顺手删除
+	c.mu.Lock()
+	if markedAt, ok := c.ids[productID]; ok && time.Since(markedAt) >= soldOutTTL {
+		delete(c.ids, productID)
+	}
+	c.mu.Unlock()
+	return false
+}
+
+// 清理过期标记，避免售罄过的商品一直留在 map 中
+func (c *SoldOutCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, markedAt := range c.ids {
+		if time.Since(markedAt) >= soldOutTTL {
+			delete(c.ids, id)
+		}
+	}
+}
+
+// 标记售罄并通知其他实例
+func (c *SoldOutCache) MarkSoldOut(productID uint) {
+	if c.set(productID, true) {
+		c.publish(productID, true)
+	}
+}
+
+// 库存恢复时清除标记并通知其他实例
+func (c *SoldOutCache) Clear(productID uint) {
+	c.set(productID, false)
+	// 本实例可能没有标记而其他实例有，始终广播
+	c.publish(productID, false)
+}
+
+// 返回本地是否发生变化
+func (c *SoldOutCache) set(productID uint, soldOut bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	markedAt, ok := c.ids[productID]
+	if soldOut {
+		c.ids[productID] = time.Now()
+		return !ok || time.Since(markedAt) >= soldOutTTL
+	}
+	delete(c.ids, productID)
+	return ok
+}
+
+func (c *SoldOutCache) publish(productID uint, soldOut bool) {
+	body, _ := json.Marshal(soldOutEvent{
+		ProductID: productID,
+		SoldOut:   soldOut,
+		Source:    utils.InstanceID(),
+	})
+	if err := redisPkg.RDB.Publish(redisPkg.Ctx, soldOutChannel, body).Err(); err != nil {
+		log.Printf("⚠️ [售罄广播失败]: productID=%d, err=%v", productID, err)
+	}
+}
+
+// 订阅其他实例的售罄广播
+func (c *SoldOutCache) Subscribe() {
+	pubsub := redisPkg.RDB.Subscribe(redisPkg.Ctx, soldOutChannel)
+	if _, err := pubsub.Receive(redisPkg.Ctx); err != nil {
+		log.Printf("⚠️ Failed to subscribe %s: %v", soldOutChannel, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(soldOutTTL)
+		defer ticker.Stop()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var event soldOutEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+				if event.Source == utils.InstanceID() {
+					continue
+				}
+				c.set(event.ProductID, event.SoldOut)
+			case <-ticker.C:
+				c.sweep()
+			}
+		}
+	}()
+	log.Println("Sold-out cache subscribed to", soldOutChannel)
+}
+
+// 当前售罄的商品ID
+func (c *SoldOutCache) Snapshot() []uint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ids := make([]uint, 0, len(c.ids))
+	for id, markedAt := range c.ids {
+		if time.Since(markedAt) < soldOutTTL {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,385 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"seckill-system/internal/model"
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 热点商品的库存可以拆成 N 个子key（stock:{id}:{shard}），按用户哈希路由分散压力；
+// 未分片的商品仍使用 stock:{id}。
+const (
+	stockShardsKey = "stock:shards"      // hash: productID -> 分片数
+	productIDsKey  = "product:ids"       // 已知商品ID集合，秒杀前校验商品是否存在
+	offShelfKey    = "product:off_shelf" // 已下架商品ID集合
+	maxStockShards = 64
+)
+
+var (
+	ErrStockNotSynced  = errors.New("stock not initialized in redis, run stock sync first")
+	ErrOutOfStock      = errors.New("out of stock")
+	ErrProductNotFound = errors.New("product not found")
+	ErrProductOffShelf = errors.New("product is off shelf")
+)
+
+// 条件扣减：key 不存在或库存不足时返回 -1，不会产生负数库存
+var decrStockScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v then return -1 end
+local n = tonumber(ARGV[1])
+if tonumber(v) < n then return -1 end
+return redis.call('DECRBY', KEYS[1], n)
+`)
+
+// 最多取走 ARGV[1] 件，返回实际取走的数量
+var takeStockScript = redis.NewScript(`
+local v = tonumber(redis.call('GET', KEYS[1]) or '0')
+local n = math.min(v, tonumber(ARGV[1]))
+if n <= 0 then return 0 end
+redis.call('DECRBY', KEYS[1], n)
+return n
+`)
+
+// 按差值调整库存（不覆盖，并发售卖不受影响）：
+// 增加时按 ARGV[2..] 分摊到各分片；减少时要求各分片合计足够，不足则整体不动
+var adjustStockScript = redis.NewScript(`
+for i = 1, #KEYS do
+	if redis.call('EXISTS', KEYS[i]) == 0 then return -1 end
+end
+local delta = tonumber(ARGV[1])
+if delta >= 0 then
+	for i = 1, #KEYS do
+		redis.call('INCRBY', KEYS[i], tonumber(ARGV[i + 1]))
+	end
+	return 1
+end
+local need = -delta
+local total = 0
+for i = 1, #KEYS do
+	total = total + tonumber(redis.call('GET', KEYS[i]))
+end
+if total < need then return 0 end
+for i = 1, #KEYS do
+	local n = math.min(tonumber(redis.call('GET', KEYS[i])), need)
+	if n > 0 then
+		redis.call('DECRBY', KEYS[i], n)
+		need = need - n
+	end
+	if need == 0 then break end
+end
+return 1
+`)
+
+// 所有分片key都不存在时才写入，保证多实例同时回灌不会互相覆盖
+var initStockScript = redis.NewScript(`
+for i = 1, #KEYS do
+	if redis.call('EXISTS', KEYS[i]) == 1 then return 0 end
+end
+for i = 1, #KEYS do
+	redis.call('SET', KEYS[i], ARGV[i])
+end
+return 1
+`)
+
+func stockKey(productID uint) string {
+	return fmt.Sprintf("stock:%d", productID)
+}
+
+// 已扣 Redis 库存、尚未被消费者落库的消息数
+func inflightKey(productID uint) string {
+	return fmt.Sprintf("stock:inflight:%d", productID)
+}
+
+func stockShardKey(productID uint, shard int) string {
+	return fmt.Sprintf("stock:%d:%d", productID, shard)
+}
+
+// 商品库存对应的全部key
+func stockKeys(productID uint, shards int) []string {
+	if shards <= 1 {
+		return []string{stockKey(productID)}
+	}
+	keys := make([]string, shards)
+	for i := range keys {
+		keys[i] = stockShardKey(productID, i)
+	}
+	return keys
+}
+
+// 把库存尽量平均地拆到各分片
+func splitStock(stock, shards int) []int {
+	if shards <= 1 {
+		return []int{stock}
+	}
+	parts := make([]int, shards)
+	for i := range parts {
+		parts[i] = stock / shards
+		if i < stock%shards {
+			parts[i]++
+		}
+	}
+	return parts
+}
+
+// 用户固定落在某个分片上
+func userShard(userID uint, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strconv.FormatUint(uint64(userID), 10)))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// 分片数缓存，商品创建后分片数不再变化
+type stockLayout struct {
+	mu     sync.RWMutex
+	shards map[uint]int
+}
+
+var layouts = &stockLayout{shards: make(map[uint]int)}
+
+func (l *stockLayout) Shards(productID uint) (int, error) {
+	l.mu.RLock()
+	n, ok := l.shards[productID]
+	l.mu.RUnlock()
+	if ok {
+		return n, nil
+	}
+
+	v, err := redisPkg.RDB.HGet(redisPkg.Ctx, stockShardsKey, strconv.FormatUint(uint64(productID), 10)).Int()
+	if err == redis.Nil {
+		// 未登记分片的商品按单key处理，不缓存，避免商品创建前的请求把结果固定下来
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if v < 1 {
+		v = 1
+	}
+	l.Set(productID, v)
+	return v, nil
+}
+
+func (l *stockLayout) Set(productID uint, shards int) {
+	l.mu.Lock()
+	l.shards[productID] = shards
+	l.mu.Unlock()
+}
+
+// 登记已知商品
+func registerProducts(productIDs ...uint) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		members[i] = id
+	}
+	return redisPkg.RDB.SAdd(redisPkg.Ctx, productIDsKey, members...).Err()
+}
+
+// 校验商品存在、在售且不是抽签模式，未知ID不允许访问任何库存key
+func checkProduct(productID uint) error {
+	pipe := redisPkg.RDB.Pipeline()
+	known := pipe.SIsMember(redisPkg.Ctx, productIDsKey, productID)
+	off := pipe.SIsMember(redisPkg.Ctx, offShelfKey, productID)
+	lottery := pipe.SIsMember(redisPkg.Ctx, lotteryProductsKey, productID)
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+		return err
+	}
+	if !known.Val() {
+		return ErrProductNotFound
+	}
+	if off.Val() {
+		return ErrProductOffShelf
+	}
+	if lottery.Val() {
+		return ErrLotteryOnly
+	}
+	return nil
+}
+
+// 同步商品上下架状态
+func setOffShelf(productID uint, off bool) error {
+	if off {
+		return redisPkg.RDB.SAdd(redisPkg.Ctx, offShelfKey, productID).Err()
+	}
+	return redisPkg.RDB.SRem(redisPkg.Ctx, offShelfKey, productID).Err()
+}
+
+// 删除商品的全部库存相关key并注销商品ID
+func removeStock(productID uint, shards int) error {
+	field := strconv.FormatUint(uint64(productID), 10)
+	pipe := redisPkg.RDB.TxPipeline()
+	pipe.SRem(redisPkg.Ctx, productIDsKey, productID)
+	pipe.SRem(redisPkg.Ctx, offShelfKey, productID)
+	pipe.SRem(redisPkg.Ctx, lotteryProductsKey, productID)
+	pipe.Del(redisPkg.Ctx, stockKeys(productID, max(shards, 1))...)
+	pipe.Del(redisPkg.Ctx, leaseKey(productID))
+	pipe.SRem(redisPkg.Ctx, leaseProductsKey, field)
+	pipe.HDel(redisPkg.Ctx, stockShardsKey, field)
+	pipe.HDel(redisPkg.Ctx, purchaseLimitsKey, field)
+	pipe.HDel(redisPkg.Ctx, productGroupsKey, field)
+	_, err := pipe.Exec(redisPkg.Ctx)
+	return err
+}
+
+// 扣减库存：先扣用户所在分片，不足时依次尝试兄弟分片，都不够时从多个分片合并扣减；
+// 返回实际扣减的分片（合并扣减时为 0）
+func deductStock(productID, userID uint, n int64) (int, error) {
+	shards, err := layouts.Shards(productID)
+	if err != nil {
+		return 0, err
+	}
+
+	start := userShard(userID, shards)
+	keys := stockKeys(productID, shards)
+	for i := 0; i < shards; i++ {
+		shard := (start + i) % shards
+		left, err := decrStockScript.Run(redisPkg.Ctx, redisPkg.RDB, []string{keys[shard]}, n).Int64()
+		if err != nil {
+			return 0, err
+		}
+		if left >= 0 {
+			return shard, nil
+		}
+	}
+	if shards > 1 && n > 1 {
+		// 单个分片都不够，但各分片合计可能足够
+		if err := adjustStock(productID, -n); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	return 0, ErrOutOfStock
+}
+
+// 归还库存到指定分片
+func restoreStock(productID uint, shard int, n int64) error {
+	shards, err := layouts.Shards(productID)
+	if err != nil {
+		return err
+	}
+	if shard < 0 || shard >= shards {
+		shard = 0
+	}
+	return redisPkg.RDB.IncrBy(redisPkg.Ctx, stockKeys(productID, shards)[shard], n).Err()
+}
+
+// 按差值增减 Redis 库存，减少时可售库存不足返回 ErrOutOfStock
+func adjustStock(productID uint, delta int64) error {
+	shards, err := layouts.Shards(productID)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{delta}
+	if delta > 0 {
+		for _, part := range splitStock(int(delta), shards) {
+			args = append(args, part)
+		}
+	}
+	res, err := adjustStockScript.Run(redisPkg.Ctx, redisPkg.RDB, stockKeys(productID, shards), args...).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case -1:
+		return ErrStockNotSynced
+	case 0:
+		return ErrOutOfStock
+	}
+	return nil
+}
+
+// 从各分片尽量取走 n 件（对账修复、下调库存用），返回实际取走的数量
+func takeStock(productID uint, n int64) (int64, error) {
+	shards, err := layouts.Shards(productID)
+	if err != nil {
+		return 0, err
+	}
+	var taken int64
+	for _, key := range stockKeys(productID, shards) {
+		if taken >= n {
+			break
+		}
+		got, err := takeStockScript.Run(redisPkg.Ctx, redisPkg.RDB, []string{key}, n-taken).Int64()
+		if err != nil {
+			return taken, err
+		}
+		taken += got
+	}
+	return taken, nil
+}
+
+// 写入商品库存（按分片拆分），overwrite=false 时只在key全部缺失时写入；返回是否写入
+func initStock(productID uint, stock, shards int, overwrite bool) (bool, error) {
+	if shards < 1 {
+		shards = 1
+	}
+	keys := stockKeys(productID, shards)
+	parts := splitStock(stock, shards)
+	args := make([]interface{}, len(parts))
+	for i, p := range parts {
+		args[i] = p
+	}
+
+	if err := redisPkg.RDB.HSet(redisPkg.Ctx, stockShardsKey, strconv.FormatUint(uint64(productID), 10), shards).Err(); err != nil {
+		return false, err
+	}
+	layouts.Set(productID, shards)
+
+	if overwrite {
+		pipe := redisPkg.RDB.TxPipeline()
+		for i, key := range keys {
+			pipe.Set(redisPkg.Ctx, key, parts[i], 0)
+		}
+		_, err := pipe.Exec(redisPkg.Ctx)
+		return err == nil, err
+	}
+
+	created, err := initStockScript.Run(redisPkg.Ctx, redisPkg.RDB, keys, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return created == 1, nil
+}
+
+// 批量读取商品当前可售库存：Redis 剩余 + 各实例租约余量；Redis 未同步的商品不返回
+func liveStock(products []model.Product) (map[uint]int64, error) {
+	pipe := redisPkg.RDB.Pipeline()
+	stockCmds := make([]*redis.SliceCmd, len(products))
+	leaseCmds := make([]*redis.StringSliceCmd, len(products))
+	for i, p := range products {
+		stockCmds[i] = pipe.MGet(redisPkg.Ctx, stockKeys(p.ID, p.StockShards)...)
+		leaseCmds[i] = pipe.HVals(redisPkg.Ctx, leaseKey(p.ID))
+	}
+	if len(products) > 0 {
+		if _, err := pipe.Exec(redisPkg.Ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+
+	live := make(map[uint]int64, len(products))
+	for i, p := range products {
+		vals := stockCmds[i].Val()
+		synced := len(vals) > 0
+		for _, v := range vals {
+			if v == nil {
+				synced = false
+			}
+		}
+		if synced {
+			live[p.ID] = sumValues(vals) + sumStrings(leaseCmds[i].Val())
+		}
+	}
+	return live, nil
+}
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redisPkg "seckill-system/internal/pkg/redis"
+	"seckill-system/internal/utils"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+// 库存预分配：每个实例从 Redis 批量租用一段库存，在内存里用原子计数售卖。
+// Redis 侧用 stock:lease:{id} 记录每个实例手里还剩多少，售卖时和购买标记一起原子扣减，
+// 因此实例崩溃后可以按 Redis 里的余量准确归还，不会多卖也不会丢库存。
+const (
+	leaseProductsKey = "stock:lease:products" // 有租约的商品集合
+	leaseAliveTTL    = 15 * time.Second       // 实例心跳过期时间，过期视为实例已崩溃
+	leaseTick        = time.Second
+)
+
+// 租用库存：从一个库存key中最多取 ARGV[2] 件记到本实例名下
+var leaseAcquireScript = redis.NewScript(`
+local v = tonumber(redis.call('GET', KEYS[1]) or '0')
+local n = math.min(v, tonumber(ARGV[2]))
+if n <= 0 then return 0 end
+redis.call('DECRBY', KEYS[1], n)
+redis.call('HINCRBY', KEYS[2], ARGV[1], n)
+redis.call('SADD', KEYS[3], ARGV[3])
+return n
+`)
+
+// 租约售卖：扣减本实例在 Redis 中的租约余量（限购额度已在调用前预占）
+// 返回 -1 租约余量不足（已被回收）
+var leaseSaleScript = redis.NewScript(`
+local left = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+local n = tonumber(ARGV[2])
+if left < n then return -1 end
+redis.call('HINCRBY', KEYS[1], ARGV[1], -n)
+return left - n
+`)
+
+// 归还租约：把实例名下的余量加回库存并删除租约
+var leaseReturnScript = redis.NewScript(`
+local left = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+redis.call('HDEL', KEYS[1], ARGV[1])
+if left > 0 then redis.call('INCRBY', KEYS[2], left) end
+if redis.call('HLEN', KEYS[1]) == 0 then redis.call('SREM', KEYS[3], ARGV[2]) end
+return left
+`)
+
+func leaseKey(productID uint) string {
+	return fmt.Sprintf("stock:lease:%d", productID)
+}
+
+func leaseAliveKey(instance string) string {
+	return fmt.Sprintf("stock:lease:alive:%s", instance)
+}
+
+type stockLease struct {
+	mu        sync.Mutex // 串行化补货与归还
+	remaining atomic.Int64
+	expiresAt time.Time
+}
+
+// 原子地从本地租约取 n 件
+func (sl *stockLease) take(n int64) bool {
+	for {
+		cur := sl.remaining.Load()
+		if cur < n {
+			return false
+		}
+		if sl.remaining.CompareAndSwap(cur, cur-n) {
+			return true
+		}
+	}
+}
+
+type StockLeaser struct {
+	Enabled   bool
+	BatchSize int64         // 每次租用的件数
+	TTL       time.Duration // 租约有效期，过期后归还未售出的库存
+	SoldOut   *SoldOutCache
+
+	mu     sync.Mutex
+	leases map[uint]*stockLease
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func NewStockLeaser(soldOut *SoldOutCache) *StockLeaser {
+	l := &StockLeaser{
+		Enabled:   viper.GetBool("seckill.lease.enabled"),
+		BatchSize: viper.GetInt64("seckill.lease.batch_size"),
+		TTL:       viper.GetDuration("seckill.lease.ttl"),
+		SoldOut:   soldOut,
+		leases:    make(map[uint]*stockLease),
+	}
+	if l.BatchSize <= 0 {
+		l.BatchSize = 50
+	}
+	if l.TTL <= 0 {
+		l.TTL = 10 * time.Second
+	}
+	return l
+}
+
+// 启动心跳与过期归还协程；崩溃实例的租约由定时任务调用 ReclaimOrphans 回收
+func (l *StockLeaser) Start() {
+	if !l.Enabled {
+		return
+	}
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.heartbeat()
+
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(leaseTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.heartbeat()
+				l.expire()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	log.Printf("Stock leaser started, batch=%d, ttl=%s, instance=%s", l.BatchSize, l.TTL, utils.InstanceID())
+}
+
+// 停止并归还本实例全部租约
+func (l *StockLeaser) Stop() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+	l.stop = nil
+
+	l.mu.Lock()
+	leases := make(map[uint]*stockLease, len(l.leases))
+	for id, sl := range l.leases {
+		leases[id] = sl
+	}
+	l.mu.Unlock()
+
+	for id, sl := range leases {
+		sl.mu.Lock()
+		l.release(id, sl)
+		sl.mu.Unlock()
+	}
+	redisPkg.RDB.Del(redisPkg.Ctx, leaseAliveKey(utils.InstanceID()))
+	log.Println("Stock leases returned")
+}
+
+// 本地租约是否还有库存
+func (l *StockLeaser) Has(productID uint) bool {
+	if !l.Enabled {
+		return false
+	}
+	l.mu.Lock()
+	sl, ok := l.leases[productID]
+	l.mu.Unlock()
+	return ok && sl.remaining.Load() > 0
+}
+
+// 从本地租约售出 n 件；sold=false 表示租约无货，调用方应走普通扣减
+func (l *StockLeaser) Sell(productID uint, n int64) (bool, error) {
+	if !l.Enabled {
+		return false, nil
+	}
+	sl := l.lease(productID)
+	if !sl.take(n) {
+		if !l.refill(productID, sl) || !sl.take(n) {
+			return false, nil
+		}
+	}
+
+	left, err := leaseSaleScript.Run(redisPkg.Ctx, redisPkg.RDB,
+		[]string{leaseKey(productID)}, utils.InstanceID(), n).Int64()
+	if err != nil {
+		sl.remaining.Add(n)
+		return false, err
+	}
+	if left == -1 {
+		// Redis 中的租约已被回收（过期或被判定崩溃），本地作废
+		sl.remaining.Store(0)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (l *StockLeaser) lease(productID uint) *stockLease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sl, ok := l.leases[productID]
+	if !ok {
+		sl = &stockLease{}
+		l.leases[productID] = sl
+	}
+	return sl
+}
+
+// 从 Redis 补货，返回是否租到库存
+func (l *StockLeaser) refill(productID uint, sl *stockLease) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	// 等锁期间其他请求可能已经补过货
+	if sl.remaining.Load() > 0 {
+		return true
+	}
+
+	shards, err := layouts.Shards(productID)
+	if err != nil {
+		log.Printf("⚠️ [租约补货失败]: productID=%d, err=%v", productID, err)
+		return false
+	}
+	instance := utils.InstanceID()
+	keys := stockKeys(productID, shards)
+	start := instanceShard(instance, shards)
+
+	var got int64
+	for i := 0; i < shards && got < l.BatchSize; i++ {
+		key := keys[(start+i)%shards]
+		n, err := leaseAcquireScript.Run(redisPkg.Ctx, redisPkg.RDB,
+			[]string{key, leaseKey(productID), leaseProductsKey},
+			instance, l.BatchSize-got, productID).Int64()
+		if err != nil {
+			log.Printf("⚠️ [租约补货失败]: productID=%d, err=%v", productID, err)
+			break
+		}
+		got += n
+	}
+	if got == 0 {
+		return false
+	}
+	sl.remaining.Add(got)
+	sl.expiresAt = time.Now().Add(l.TTL)
+	return true
+}
+
+// 归还过期租约
+func (l *StockLeaser) expire() {
+	l.mu.Lock()
+	leases := make(map[uint]*stockLease, len(l.leases))
+	for id, sl := range l.leases {
+		leases[id] = sl
+	}
+	l.mu.Unlock()
+
+	now := time.Now()
+	for id, sl := range leases {
+		sl.mu.Lock()
+		if !sl.expiresAt.IsZero() && now.After(sl.expiresAt) {
+			l.release(id, sl)
+		}
+		sl.mu.Unlock()
+	}
+}
+
+// 调用方持有 sl.mu
+func (l *StockLeaser) release(productID uint, sl *stockLease) {
+	sl.remaining.Store(0)
+	sl.expiresAt = time.Time{}
+	left, err := returnLease(productID, utils.InstanceID())
+	if err != nil {
+		log.Printf("⚠️ [租约归还失败]: productID=%d, err=%v", productID, err)
+		return
+	}
+	if left > 0 && l.SoldOut != nil {
+		l.SoldOut.Clear(productID)
+	}
+}
+
+func (l *StockLeaser) heartbeat() {
+	redisPkg.RDB.Set(redisPkg.Ctx, leaseAliveKey(utils.InstanceID()), 1, leaseAliveTTL)
+}
+
+// 回收心跳已过期实例名下的租约
+func (l *StockLeaser) ReclaimOrphans() (int64, error) {
+	ids, err := redisPkg.RDB.SMembers(redisPkg.Ctx, leaseProductsKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	self := utils.InstanceID()
+	var total int64
+	for _, idStr := range ids {
+		productID := utils.StrToUint(idStr)
+		if productID == 0 {
+			continue
+		}
+		instances, err := redisPkg.RDB.HKeys(redisPkg.Ctx, leaseKey(productID)).Result()
+		if err != nil {
+			return total, err
+		}
+		for _, instance := range instances {
+			if instance == self {
+				continue
+			}
+			alive, err := redisPkg.RDB.Exists(redisPkg.Ctx, leaseAliveKey(instance)).Result()
+			if err != nil {
+				return total, err
+			}
+			if alive > 0 {
+				continue
+			}
+			left, err := returnLease(productID, instance)
+			if err != nil {
+				return total, err
+			}
+			if left > 0 {
+				log.Printf("♻️ [回收租约]: productID=%d, instance=%s, units=%d", productID, instance, left)
+				if l.SoldOut != nil {
+					l.SoldOut.Clear(productID)
+				}
+			}
+			total += left
+		}
+	}
+	return total, nil
+}
+
+// 把实例名下的租约余量归还到商品的第一个库存key
+func returnLease(productID uint, instance string) (int64, error) {
+	shards, err := layouts.Shards(productID)
+	if err != nil {
+		return 0, err
+	}
+	return leaseReturnScript.Run(redisPkg.Ctx, redisPkg.RDB,
+		[]string{leaseKey(productID), stockKeys(productID, shards)[0], leaseProductsKey},
+		instance, strconv.FormatUint(uint64(productID), 10)).Int64()
+}
+
+// 实例租货时从固定分片开始，避免所有实例挤在同一个分片上
+func instanceShard(instance string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(instance))
+	return int(h.Sum32() % uint32(shards))
+}
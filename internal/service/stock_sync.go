@@ -0,0 +1,215 @@
+package service
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"seckill-system/internal/model"
+	"seckill-system/internal/pkg/lock"
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	syncBatchSize = 500
+	syncLockName  = "stock:sync"
+	syncLockTTL   = 5 * time.Minute
+)
+
+// 回灌结果，按库存key计数（分片商品每个分片算一个key）
+type SyncReport struct {
+	Authoritative bool   `json:"authoritative"`
+	Products      int    `json:"products"`
+	Created       int    `json:"created"`     // 原本缺失、新写入
+	Skipped       int    `json:"skipped"`     // 已存在未改动（权威模式下值已一致）
+	Overwritten   int    `json:"overwritten"` // 权威模式下被 MySQL 值覆盖
+	SKUs          int    `json:"skus"`        // 同步的规格数
+	Bundles       int    `json:"bundles"`     // 同步组成的组合商品数
+	Duration      string `json:"duration"`
+}
+
+// 加锁回灌，多实例同时调用时只有一个执行，其余返回 lock.ErrNotAcquired
+func (s *ProductService) SyncStock(authoritative bool) (*SyncReport, error) {
+	l, err := lock.Acquire(syncLockName, syncLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return s.SyncStockToRedis(authoritative)
+}
+
+// 回灌DB库存到redis，分页读取商品、每页一次 pipeline
+// authoritative=false 时用 MSETNX 只补齐缺失的key；为 true 时以 MySQL 为准覆盖，
+// 写入值为 DB库存 - 在途消息 - 实例租约（这些已从 Redis 扣掉但还没落库）
+func (s *ProductService) SyncStockToRedis(authoritative bool) (*SyncReport, error) {
+	start := time.Now()
+	report := &SyncReport{Authoritative: authoritative}
+
+	if err := syncCampaignGroups(s.DB); err != nil {
+		return nil, err
+	}
+	if err := syncLotteryProducts(s.DB); err != nil {
+		return nil, err
+	}
+	// 组成先于商品ID登记，见 bundleLayout
+	if err := s.syncBundles(report); err != nil {
+		return nil, err
+	}
+
+	var lastID uint
+	for {
+		var products []model.Product
+		err := s.DB.Select("id", "stock", "stock_shards", "status", "purchase_limit", "campaign_group_id").
+			Where("id > ?", lastID).Order("id").Limit(syncBatchSize).
+			Find(&products).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(products) == 0 {
+			break
+		}
+		lastID = products[len(products)-1].ID
+		report.Products += len(products)
+
+		if authoritative {
+			err = syncBatchAuthoritative(products, report)
+		} else {
+			err = syncBatchMissing(products, report)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.syncSKUs(authoritative, report); err != nil {
+		return nil, err
+	}
+
+	report.Duration = time.Since(start).String()
+	log.Printf("🔄 [库存回灌完成]: authoritative=%v, products=%d, created=%d, skipped=%d, overwritten=%d, cost=%s",
+		authoritative, report.Products, report.Created, report.Skipped, report.Overwritten, report.Duration)
+	return report, nil
+}
+
+// 登记分片数、商品ID、上下架状态、每人限购与所属活动组
+func syncLayout(pipe redis.Pipeliner, products []model.Product) {
+	fields := make(map[string]interface{}, len(products))
+	ids := make([]interface{}, len(products))
+	var onShelf, offShelf []interface{}
+	for i, p := range products {
+		shards := max(p.StockShards, 1)
+		fields[strconv.FormatUint(uint64(p.ID), 10)] = shards
+		ids[i] = p.ID
+		if p.Status == model.ProductOffShelf {
+			offShelf = append(offShelf, p.ID)
+		} else {
+			onShelf = append(onShelf, p.ID)
+		}
+		layouts.Set(p.ID, shards)
+		setPurchaseLimit(pipe, p.ID, p.PurchaseLimit)
+		setProductGroup(pipe, p.ID, p.CampaignGroupID)
+	}
+	pipe.HSet(redisPkg.Ctx, stockShardsKey, fields)
+	pipe.SAdd(redisPkg.Ctx, productIDsKey, ids...)
+	if len(offShelf) > 0 {
+		pipe.SAdd(redisPkg.Ctx, offShelfKey, offShelf...)
+	}
+	if len(onShelf) > 0 {
+		pipe.SRem(redisPkg.Ctx, offShelfKey, onShelf...)
+	}
+}
+
+// 只补齐缺失：MSETNX 保证一个商品的所有分片要么一起写入，要么都不动
+func syncBatchMissing(products []model.Product, report *SyncReport) error {
+	pipe := redisPkg.RDB.Pipeline()
+	syncLayout(pipe, products)
+	cmds := make([]*redis.BoolCmd, len(products))
+	for i, p := range products {
+		shards := max(p.StockShards, 1)
+		keys := stockKeys(p.ID, shards)
+		parts := splitStock(p.Stock, shards)
+		pairs := make([]interface{}, 0, len(keys)*2)
+		for j, key := range keys {
+			pairs = append(pairs, key, parts[j])
+		}
+		cmds[i] = pipe.MSetNX(redisPkg.Ctx, pairs...)
+	}
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+		return err
+	}
+
+	for i, p := range products {
+		n := max(p.StockShards, 1)
+		if cmds[i].Val() {
+			report.Created += n
+		} else {
+			report.Skipped += n
+		}
+	}
+	return nil
+}
+
+// 权威覆盖单个商品：在脚本内读取在途与租约并写入 DB库存 - 在途 - 租约，
+// 读写之间不会插入售卖，避免覆盖掉刚扣减的库存。只写有变化的分片，按 splitStock 的方式拆分
+// KEYS: 各分片库存key..., 在途计数, [租约hash]  ARGV: MySQL库存, 分片数
+// 返回每个分片的结果：0 未改动，1 原本缺失，2 被覆盖
+var authoritativeStockScript = redis.NewScript(`
+local n = tonumber(ARGV[2])
+local pending = tonumber(redis.call('GET', KEYS[n + 1]) or '0')
+if #KEYS > n + 1 then
+	for _, v in ipairs(redis.call('HVALS', KEYS[n + 2])) do pending = pending + tonumber(v) end
+end
+local target = math.max(tonumber(ARGV[1]) - pending, 0)
+local base = math.floor(target / n)
+local extra = target % n
+local res = {}
+for i = 1, n do
+	local part = base
+	if i <= extra then part = part + 1 end
+	local cur = redis.call('GET', KEYS[i])
+	if not cur then
+		res[i] = 1
+	elseif tonumber(cur) == part then
+		res[i] = 0
+	else
+		res[i] = 2
+	end
+	if res[i] ~= 0 then redis.call('SET', KEYS[i], part) end
+end
+return res
+`)
+
+func syncBatchAuthoritative(products []model.Product, report *SyncReport) error {
+	pipe := redisPkg.RDB.Pipeline()
+	syncLayout(pipe, products)
+	cmds := make([]*redis.Cmd, len(products))
+	for i, p := range products {
+		shards := max(p.StockShards, 1)
+		keys := append(stockKeys(p.ID, shards), inflightKey(p.ID), leaseKey(p.ID))
+		// pipeline 中无法处理 NOSCRIPT 回退，直接 EVAL
+		cmds[i] = authoritativeStockScript.Eval(redisPkg.Ctx, pipe, keys, p.Stock, shards)
+	}
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		countSyncResults(cmd, report)
+	}
+	return nil
+}
+
+func countSyncResults(cmd *redis.Cmd, report *SyncReport) {
+	res, _ := cmd.Int64Slice()
+	for _, r := range res {
+		switch r {
+		case 0:
+			report.Skipped++
+		case 1:
+			report.Created++
+		default:
+			report.Overwritten++
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	redisPkg "seckill-system/internal/pkg/redis"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+// 虚拟排队：到达时按商品领取递增的排队号，leader 上的定时任务按配置速率推进"已放行到第几号"，
+// 排到的用户领取短时有效的签名放行令牌，秒杀接口只接受带有效令牌的请求。
+// 令牌的过期时间在用户首次被确认放行时记录，之后只返回同一个令牌；
+// 令牌用于一次秒杀即作废，作废或过期后用户可重新进入排队领取新号
+const (
+	waitingRoomActiveKey         = "waitroom:active" // 有人在排队的商品ID集合
+	waitingRoomSecretPlaceholder = "waiting-room-secret-change-this"
+)
+
+var (
+	ErrNotInQueue          = errors.New("not in the waiting room queue")
+	ErrAdmissionRequired   = errors.New("admission token required, join the waiting room first")
+	ErrInvalidAdmission    = errors.New("invalid admission token")
+	ErrAdmissionExpired    = errors.New("admission token expired")
+	ErrAdmissionUsed       = errors.New("admission token already used, join the waiting room again")
+	waitingRoomKeepTTL     = 24 * time.Hour
+	defaultWaitingRoomRate = int64(100)
+)
+
+func waitingRoomSeqKey(productID uint) string {
+	return fmt.Sprintf("waitroom:%d:seq", productID)
+}
+
+func waitingRoomUsersKey(productID uint) string {
+	return fmt.Sprintf("waitroom:%d:users", productID)
+}
+
+func waitingRoomAdmittedKey(productID uint) string {
+	return fmt.Sprintf("waitroom:%d:admitted", productID)
+}
+
+// hash: uid -> 放行令牌过期时间戳
+func waitingRoomExpiresKey(productID uint) string {
+	return fmt.Sprintf("waitroom:%d:expires", productID)
+}
+
+// 首次放行时记录令牌过期时间，已记录的不变，返回记录的过期时间戳
+var admissionExpiryScript = redis.NewScript(`
+redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return tonumber(redis.call('HGET', KEYS[1], ARGV[1]))
+`)
+
+// 使用放行令牌：令牌仍是该用户当前这次放行时作废，并移出队列以便重新排队
+var useAdmissionScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return 1
+`)
+
+// 领取排队号：已在队列中的用户返回原来的号，放行令牌已过期的重新领号，返回 {排队号, 已放行到的号}
+var joinQueueScript = redis.NewScript(`
+local ticket = redis.call('HGET', KEYS[2], ARGV[1])
+local expires = tonumber(redis.call('HGET', KEYS[5], ARGV[1]) or '0')
+if ticket and expires > 0 and expires <= tonumber(ARGV[4]) then
+	redis.call('HDEL', KEYS[5], ARGV[1])
+	ticket = nil
+end
+if not ticket then
+	ticket = redis.call('INCR', KEYS[1])
+	redis.call('HSET', KEYS[2], ARGV[1], ticket)
+end
+local ttl = tonumber(ARGV[2])
+redis.call('EXPIRE', KEYS[1], ttl)
+redis.call('EXPIRE', KEYS[2], ttl)
+redis.call('SADD', KEYS[4], ARGV[3])
+return {tonumber(ticket), tonumber(redis.call('GET', KEYS[3]) or '0')}
+`)
+
+// 放行：已放行号最多推进 ARGV[1] 个，不超过已发出的号；全部放行后移出活跃集合
+var admitScript = redis.NewScript(`
+local seq = tonumber(redis.call('GET', KEYS[1]) or '0')
+local admitted = tonumber(redis.call('GET', KEYS[2]) or '0')
+if admitted >= seq then
+	redis.call('SREM', KEYS[3], ARGV[2])
+	return 0
+end
+local n = math.min(seq - admitted, tonumber(ARGV[1]))
+redis.call('SET', KEYS[2], admitted + n, 'EX', tonumber(ARGV[3]))
+return n
+`)
+
+type WaitingRoom struct {
+	Enabled  bool
+	Rate     int64         // 每秒放行人数（每个商品）
+	Interval time.Duration // 放行任务间隔
+	TokenTTL time.Duration // 放行令牌有效期
+	secret   []byte
+}
+
+// 开启排队时必须配置自己的签名密钥，否则任何人都能伪造令牌
+func NewWaitingRoom() (*WaitingRoom, error) {
+	w := &WaitingRoom{
+		Enabled:  viper.GetBool("seckill.waiting_room.enabled"),
+		Rate:     viper.GetInt64("seckill.waiting_room.rate"),
+		Interval: viper.GetDuration("seckill.waiting_room.admit_interval"),
+		TokenTTL: viper.GetDuration("seckill.waiting_room.token_ttl"),
+		secret:   []byte(viper.GetString("seckill.waiting_room.secret")),
+	}
+	if w.Rate <= 0 {
+		w.Rate = defaultWaitingRoomRate
+	}
+	if w.Interval <= 0 {
+		w.Interval = time.Second
+	}
+	if w.TokenTTL <= 0 {
+		w.TokenTTL = time.Minute
+	}
+	if w.Enabled && (len(w.secret) == 0 || string(w.secret) == waitingRoomSecretPlaceholder) {
+		return nil, errors.New("seckill.waiting_room.secret must be set to a private value when the waiting room is enabled")
+	}
+	return w, nil
+}
+
+// 排队状态；放行后附带令牌，请求秒杀时放在 X-Admission-Token 请求头
+type QueueStatus struct {
+	ProductID     uint       `json:"product_id"`
+	Ticket        int64      `json:"ticket"`
+	Position      int64      `json:"position"` // 前面还有多少人，0 表示已放行
+	Admitted      bool       `json:"admitted"`
+	Expired       bool       `json:"expired,omitempty"` // 放行令牌已过期，重新进入排队可领取新号
+	EstimatedWait int64      `json:"estimated_wait_seconds,omitempty"`
+	Token         string     `json:"token,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// 进入排队，重复进入不会改变排队号；放行令牌过期后再进入会排到队尾
+func (w *WaitingRoom) Join(productID, userID uint) (*QueueStatus, error) {
+	if err := checkProduct(productID); err != nil {
+		return nil, err
+	}
+	keys := []string{
+		waitingRoomSeqKey(productID),
+		waitingRoomUsersKey(productID),
+		waitingRoomAdmittedKey(productID),
+		waitingRoomActiveKey,
+		waitingRoomExpiresKey(productID),
+	}
+	res, err := joinQueueScript.Run(redisPkg.Ctx, redisPkg.RDB, keys,
+		userID, int(waitingRoomKeepTTL.Seconds()), productID, time.Now().Unix()).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	return w.status(productID, userID, res[0], res[1])
+}
+
+// 查询排队位置，排到时签发令牌
+func (w *WaitingRoom) Position(productID, userID uint) (*QueueStatus, error) {
+	pipe := redisPkg.RDB.Pipeline()
+	ticket := pipe.HGet(redisPkg.Ctx, waitingRoomUsersKey(productID), strconv.FormatUint(uint64(userID), 10))
+	admitted := pipe.Get(redisPkg.Ctx, waitingRoomAdmittedKey(productID))
+	if _, err := pipe.Exec(redisPkg.Ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if ticket.Err() == redis.Nil {
+		return nil, ErrNotInQueue
+	}
+	return w.status(productID, userID, parseInt64(ticket.Val()), parseInt64(admitted.Val()))
+}
+
+func (w *WaitingRoom) status(productID, userID uint, ticket, admitted int64) (*QueueStatus, error) {
+	st := &QueueStatus{ProductID: productID, Ticket: ticket}
+	if ticket > admitted {
+		st.Position = ticket - admitted
+		st.EstimatedWait = (st.Position + w.Rate - 1) / w.Rate
+		return st, nil
+	}
+	st.Admitted = true
+	unix, err := admissionExpiryScript.Run(redisPkg.Ctx, redisPkg.RDB, []string{waitingRoomExpiresKey(productID)},
+		userID, time.Now().Add(w.TokenTTL).Unix(), int(waitingRoomKeepTTL.Seconds())).Int64()
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Unix(unix, 0)
+	if !time.Now().Before(expires) {
+		st.Expired = true
+		return st, nil
+	}
+	// 签名只取决于用户、商品和过期时间，重复查询得到的是同一个令牌
+	st.Token = w.sign(userID, productID, expires)
+	st.ExpiresAt = &expires
+	return st, nil
+}
+
+// 定时任务入口：每个有人排队的商品按速率推进放行号
+func (w *WaitingRoom) Admit(ctx context.Context) error {
+	ids, err := redisPkg.RDB.SMembers(redisPkg.Ctx, waitingRoomActiveKey).Result()
+	if err != nil {
+		return err
+	}
+	step := max(w.Rate*int64(w.Interval)/int64(time.Second), 1)
+	for _, field := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		productID := uint(parseInt64(field))
+		keys := []string{waitingRoomSeqKey(productID), waitingRoomAdmittedKey(productID), waitingRoomActiveKey}
+		err := admitScript.Run(redisPkg.Ctx, redisPkg.RDB, keys, step, field, int(waitingRoomKeepTTL.Seconds())).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 令牌格式：base64url("uid:productID:过期时间戳") + "." + base64url(HMAC-SHA256)
+func (w *WaitingRoom) sign(userID, productID uint, expires time.Time) string {
+	payload := fmt.Sprintf("%d:%d:%d", userID, productID, expires.Unix())
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 校验令牌属于该用户、该商品且未过期；未开启排队时直接通过
+func (w *WaitingRoom) VerifyAdmission(token string, userID, productID uint) error {
+	if !w.Enabled {
+		return nil
+	}
+	_, err := w.verify(token, userID, productID)
+	return err
+}
+
+// 校验并作废令牌，每次放行只能用于一次秒杀请求
+func (w *WaitingRoom) UseAdmission(token string, userID, productID uint) error {
+	if !w.Enabled {
+		return nil
+	}
+	expires, err := w.verify(token, userID, productID)
+	if err != nil {
+		return err
+	}
+	used, err := useAdmissionScript.Run(redisPkg.Ctx, redisPkg.RDB,
+		[]string{waitingRoomExpiresKey(productID), waitingRoomUsersKey(productID)}, userID, expires).Int()
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return ErrAdmissionUsed
+	}
+	return nil
+}
+
+// 校验签名、用户、商品与过期时间，返回令牌中的过期时间戳
+func (w *WaitingRoom) verify(token string, userID, productID uint) (int64, error) {
+	if token == "" {
+		return 0, ErrAdmissionRequired
+	}
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidAdmission
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return 0, ErrInvalidAdmission
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return 0, ErrInvalidAdmission
+	}
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return 0, ErrInvalidAdmission
+	}
+
+	parts := strings.Split(string(payload), ":")
+	if len(parts) != 3 || parts[0] != strconv.FormatUint(uint64(userID), 10) ||
+		parts[1] != strconv.FormatUint(uint64(productID), 10) {
+		return 0, ErrInvalidAdmission
+	}
+	expires := parseInt64(parts[2])
+	if time.Now().Unix() > expires {
+		return 0, ErrAdmissionExpired
+	}
+	return expires, nil
+}
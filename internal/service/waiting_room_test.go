@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func testWaitingRoom(secret string) *WaitingRoom {
+	return &WaitingRoom{Enabled: true, Rate: 10, Interval: time.Second, TokenTTL: time.Minute, secret: []byte(secret)}
+}
+
+func TestAdmissionToken(t *testing.T) {
+	w := testWaitingRoom("test-secret")
+	valid := w.sign(7, 100, time.Now().Add(time.Minute))
+	payload, sig, _ := strings.Cut(valid, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("8:100:9999999999")) + "." + sig
+
+	tests := []struct {
+		name    string
+		w       *WaitingRoom
+		token   string
+		userID  uint
+		product uint
+		err     error
+	}{
+		{"valid", w, valid, 7, 100, nil},
+		{"other user", w, valid, 8, 100, ErrInvalidAdmission},
+		{"other product", w, valid, 7, 101, ErrInvalidAdmission},
+		{"expired", w, w.sign(7, 100, time.Now().Add(-time.Second)), 7, 100, ErrAdmissionExpired},
+		{"missing", w, "", 7, 100, ErrAdmissionRequired},
+		{"no separator", w, payload, 7, 100, ErrInvalidAdmission},
+		{"bad payload encoding", w, "!!!." + sig, 7, 100, ErrInvalidAdmission},
+		{"bad signature encoding", w, payload + ".!!!", 7, 100, ErrInvalidAdmission},
+		{"tampered payload", w, forged, 8, 100, ErrInvalidAdmission},
+		{"truncated signature", w, valid[:len(valid)-2], 7, 100, ErrInvalidAdmission},
+		{"signed with another secret", testWaitingRoom("other-secret"), valid, 7, 100, ErrInvalidAdmission},
+		{"disabled accepts anything", &WaitingRoom{}, "", 7, 100, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.w.VerifyAdmission(tt.token, tt.userID, tt.product)
+			if tt.err == nil && err != nil {
+				t.Fatalf("VerifyAdmission unexpected error: %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("VerifyAdmission error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// 同一用户、商品、过期时间签出的令牌相同，重复查询不会得到新令牌
+func TestAdmissionTokenStable(t *testing.T) {
+	w := testWaitingRoom("test-secret")
+	expires := time.Now().Add(time.Minute)
+	if a, b := w.sign(7, 100, expires), w.sign(7, 100, expires); a != b {
+		t.Errorf("sign is not deterministic: %s != %s", a, b)
+	}
+	if a, b := w.sign(7, 100, expires), w.sign(7, 100, expires.Add(time.Second)); a == b {
+		t.Error("tokens with different expiry must differ")
+	}
+}
+
+func TestNewWaitingRoomSecret(t *testing.T) {
+	defer viper.Reset()
+	tests := []struct {
+		enabled bool
+		secret  string
+		wantErr bool
+	}{
+		{false, "", false},
+		{false, waitingRoomSecretPlaceholder, false},
+		{true, "", true},
+		{true, waitingRoomSecretPlaceholder, true},
+		{true, "a-private-secret", false},
+	}
+	for _, tt := range tests {
+		viper.Set("seckill.waiting_room.enabled", tt.enabled)
+		viper.Set("seckill.waiting_room.secret", tt.secret)
+		_, err := NewWaitingRoom()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewWaitingRoom(enabled=%v, secret=%q) error = %v, wantErr %v", tt.enabled, tt.secret, err, tt.wantErr)
+		}
+	}
+}
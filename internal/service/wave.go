@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"seckill-system/internal/model"
+
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// 分批放量：到点由 leader 上的定时任务按补货流程（MySQL 库存 + 流水 + Redis 差值）放出库存，
+// 与手工补货走同一条路径，对账不会把放量当成差异
+const (
+	waveBatchSize = 100
+	maxWaves      = 100
+)
+
+var (
+	ErrInvalidWave  = errors.New("invalid stock wave")
+	errWaveReleased = errors.New("wave already released")
+)
+
+type WaveService struct {
+	DB        *gorm.DB
+	Inventory *InventoryService
+	Enabled   bool
+	Interval  time.Duration // 检查到期批次的间隔，决定放量的最大延迟
+}
+
+func NewWaveService(db *gorm.DB, inventory *InventoryService) *WaveService {
+	s := &WaveService{
+		DB:        db,
+		Inventory: inventory,
+		Enabled:   viper.GetBool("seckill.waves.enabled"),
+		Interval:  viper.GetDuration("seckill.waves.check_interval"),
+	}
+	if s.Interval <= 0 {
+		s.Interval = time.Second
+	}
+	return s
+}
+
+type WaveParams struct {
+	ProductID uint      `json:"product_id"`
+	ReleaseAt time.Time `json:"release_at"`
+	Quantity  int       `json:"quantity"`
+}
+
+type WaveView struct {
+	ID              uint       `json:"id"`
+	CampaignGroupID uint       `json:"campaign_group_id"`
+	ProductID       uint       `json:"product_id"`
+	ReleaseAt       time.Time  `json:"release_at"`
+	Quantity        int        `json:"quantity"`
+	Released        bool       `json:"released"`
+	ReleasedAt      *time.Time `json:"released_at,omitempty"`
+}
+
+func newWaveView(w *model.StockWave) WaveView {
+	return WaveView{
+		ID:              w.ID,
+		CampaignGroupID: w.CampaignGroupID,
+		ProductID:       w.ProductID,
+		ReleaseAt:       w.ReleaseAt,
+		Quantity:        w.Quantity,
+		Released:        w.ReleasedAt != nil,
+		ReleasedAt:      w.ReleasedAt,
+	}
+}
+
+// 替换活动组尚未放出的批次，已放出的批次保留作为记录。
+// 商品必须属于该活动组，且不能是组合商品或有规格的商品（它们没有商品级库存）
+func (s *WaveService) SetWaves(groupID uint, waves []WaveParams) ([]WaveView, error) {
+	if len(waves) > maxWaves {
+		return nil, fmt.Errorf("%w: at most %d waves", ErrInvalidWave, maxWaves)
+	}
+	now := time.Now()
+	ids := make([]uint, 0, len(waves))
+	for _, w := range waves {
+		if w.ProductID == 0 || w.Quantity < 1 {
+			return nil, fmt.Errorf("%w: product_id and a positive quantity are required", ErrInvalidWave)
+		}
+		if !w.ReleaseAt.After(now) {
+			return nil, fmt.Errorf("%w: release_at must be in the future", ErrInvalidWave)
+		}
+		ids = append(ids, w.ProductID)
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&model.CampaignGroup{}, groupID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCampaignGroupNotFound
+			}
+			return err
+		}
+		if len(ids) > 0 {
+			var inGroup []uint
+			err := tx.Model(&model.Product{}).Where("id IN ? AND campaign_group_id = ?", ids, groupID).
+				Pluck("id", &inGroup).Error
+			if err != nil {
+				return err
+			}
+			member := make(map[uint]bool, len(inGroup))
+			for _, id := range inGroup {
+				member[id] = true
+			}
+			for _, id := range ids {
+				if !member[id] {
+					return fmt.Errorf("%w: product %d is not in campaign group %d", ErrInvalidWave, id, groupID)
+				}
+			}
+			var count int64
+			if err := tx.Model(&model.BundleItem{}).Where("bundle_id IN ?", ids).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				err = tx.Model(&model.SKU{}).Where("product_id IN ?", ids).Count(&count).Error
+			}
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: bundles and products with skus cannot have waves", ErrInvalidWave)
+			}
+		}
+
+		err := tx.Where("campaign_group_id = ? AND released_at IS NULL", groupID).Delete(&model.StockWave{}).Error
+		if err != nil {
+			return err
+		}
+		if len(waves) == 0 {
+			return nil
+		}
+		rows := make([]model.StockWave, len(waves))
+		for i, w := range waves {
+			rows[i] = model.StockWave{
+				CampaignGroupID: groupID,
+				ProductID:       w.ProductID,
+				ReleaseAt:       w.ReleaseAt,
+				Quantity:        w.Quantity,
+			}
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🌊 [放量计划已更新]: groupID=%d, waves=%d", groupID, len(waves))
+	return s.List(groupID)
+}
+
+// 活动组的全部批次，按放量时间排序
+func (s *WaveService) List(groupID uint) ([]WaveView, error) {
+	var rows []model.StockWave
+	if err := s.DB.Where("campaign_group_id = ?", groupID).Order("release_at, id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	views := make([]WaveView, len(rows))
+	for i := range rows {
+		views[i] = newWaveView(&rows[i])
+	}
+	return views, nil
+}
+
+// 商品的下一批放量，没有待放批次时返回 nil
+func (s *WaveService) NextWave(productID uint) (*WaveView, error) {
+	var wave model.StockWave
+	err := s.DB.Where("product_id = ? AND released_at IS NULL", productID).
+		Order("release_at, id").First(&wave).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	view := newWaveView(&wave)
+	return &view, nil
+}
+
+// 活动组内各商品的下一批放量
+func (s *WaveService) NextWaves(groupID uint) ([]WaveView, error) {
+	var rows []model.StockWave
+	err := s.DB.Where("campaign_group_id = ? AND released_at IS NULL", groupID).
+		Order("release_at, id").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint]bool)
+	views := []WaveView{}
+	for i := range rows {
+		if seen[rows[i].ProductID] {
+			continue
+		}
+		seen[rows[i].ProductID] = true
+		views = append(views, newWaveView(&rows[i]))
+	}
+	return views, nil
+}
+
+// 定时任务入口：放出所有到期批次，单个批次失败不影响其他批次，下一轮重试
+func (s *WaveService) ReleaseDue(ctx context.Context) error {
+	var waves []model.StockWave
+	err := s.DB.Where("released_at IS NULL AND release_at <= ?", time.Now()).
+		Order("release_at, id").Limit(waveBatchSize).Find(&waves).Error
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for i := range waves {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.release(&waves[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 放出一个批次：认领批次与补货在同一事务，重复执行不会重复放量
+func (s *WaveService) release(w *model.StockWave) error {
+	reason := fmt.Sprintf("wave %d of campaign group %d", w.ID, w.CampaignGroupID)
+	adj, err := s.Inventory.adjust(w.ProductID, 0, StockModeAdd, w.Quantity, reason, "scheduler",
+		func(tx *gorm.DB, adj *StockAdjustment) error {
+			return claimWave(tx, w.ID, &adj.LedgerID)
+		})
+	switch {
+	case errors.Is(err, errWaveReleased):
+		return nil
+	case errors.Is(err, ErrProductNotFound):
+		// 商品已归档，批次作废，避免每轮重试
+		log.Printf("⚠️ [放量跳过]: waveID=%d, productID=%d 已归档", w.ID, w.ProductID)
+		return claimWave(s.DB, w.ID, nil)
+	case err != nil:
+		log.Printf("⚠️ [放量失败]: waveID=%d, productID=%d, err=%v", w.ID, w.ProductID, err)
+		return err
+	}
+	log.Printf("🌊 [放量]: waveID=%d, productID=%d, +%d, stock %d -> %d", w.ID, w.ProductID, w.Quantity, adj.Before, adj.After)
+	return nil
+}
+
+func claimWave(tx *gorm.DB, waveID uint, ledgerID *uint) error {
+	result := tx.Model(&model.StockWave{}).Where("id = ? AND released_at IS NULL", waveID).
+		Updates(map[string]interface{}{"released_at": time.Now(), "ledger_id": ledgerID})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errWaveReleased
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	instanceOnce sync.Once
+	instanceID   string
+)
+
+// 当前实例标识：主机名（容器名）+ 进程号
+func InstanceID() string {
+	instanceOnce.Do(func() {
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = "unknown"
+		}
+		instanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	})
+	return instanceID
+}